@@ -0,0 +1,76 @@
+/*
+ * Holds dead letters
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+// Why event delivery ended up in dead letters.
+type DeadLetterReason int
+
+const (
+	ReasonPanicked DeadLetterReason = iota + 1 // Handler panicked
+	ReasonFailed                               // Handler returned error, and retries (if any) are exhausted
+	ReasonDropped                              // Delivery was dropped or rejected by dispatcher, mailbox or publish middleware
+	ReasonTimedOut                             // Handler didn't return within timeout
+)
+
+func (r DeadLetterReason) String() string {
+	switch r {
+	case ReasonPanicked:
+		return "panicked"
+	case ReasonFailed:
+		return "failed"
+	case ReasonDropped:
+		return "dropped"
+	case ReasonTimedOut:
+		return "timed out"
+	default:
+		return "unknown"
+	}
+}
+
+// Event delivery that couldn't be completed. See Bus.SetDeadLetterSink.
+type DeadLetter[EData any] struct {
+	Event      Event[EData]      // Original event. Its Topic and Data can be used to replay it
+	Subscriber Subscriber[EData] // Failed subscriber, see Subscriber.Pattern. Zero value for the unhandled sink
+	Reason     DeadLetterReason
+	Err        error // Cause: handler error, `*PanicError`, `ErrHandlerTimeout` or dispatcher error
+	Attempts   int   // Number of times handler was called, zero if delivery was dropped
+}
+
+// Registers sink for event deliveries that failed: handler panicked, returned error past its retry budget,
+// timed out, or delivery was dropped by overflowing queue.
+// Sink is called synchronously by the goroutine that detected the failure, so it should be fast.
+// Simply set to nil to unregister. No-op if the bus is closed.
+func (b *Bus[EData]) SetDeadLetterSink(sink func(dl DeadLetter[EData])) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update(func(s *snapshot[EData]) {
+		s.deadLetterSink = sink
+	})
+}
+
+// Passes delivery failure to dead letter sink, if any. Drops are measured and logged here.
+func (b *Bus[EData]) deadLetter(dl DeadLetter[EData]) {
+	if dl.Reason == ReasonDropped {
+		if b.metrics != nil {
+			b.metrics.Dropped(dl.Subscriber.pattern, dl.Err)
+		}
+		b.logDropped(dl)
+	}
+	if sink := b.state.Load().deadLetterSink; sink != nil {
+		sink(dl)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+ * Holds event delivery to a single handler
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Recovered handler panic.
+type PanicError struct {
+	Value any    // Value passed to panic
+	Stack []byte // Stack trace of the panicked goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Handler panic details, passed to the panic handler. See Bus.SetPanicHandler.
+type HandlerPanic[EData any] struct {
+	Value      any               // Value passed to panic
+	Stack      []byte            // Stack trace of the panicked goroutine
+	Subscriber Subscriber[EData] // Panicked subscriber. Zero value for the unhandled sink
+	Event      Event[EData]      // Event being handled
+}
+
+// Where stack of handler panic is written before it's re-raised, see Bus.SetPanicHandler.
+var panicOutput io.Writer = os.Stderr
+
+// Subscriber went away between matching and delivery. Not reported as error.
+var errUnsubscribed = errors.New("unsubscribed")
+
+// Delivery of an event to a single handler, dispatched as Task.
+type delivery[EData any] struct {
+	bus      *Bus[EData]
+	sub      Subscriber[EData]
+	ev       Event[EData]
+	done     atomic.Bool
+	state    atomic.Int32  // See deliveryRunning and others
+	attempts atomic.Int32  // Number of handler calls so far
+	finished chan struct{} // If not nil, closed after Run or Drop. Used by PublishSync
+}
+
+// Delivery states. Whoever moves delivery out of running state, reports its outcome.
+const (
+	deliveryRunning int32 = iota
+	deliveryFinished
+	deliveryExpired
+)
+
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// Slow handler details, passed to the watchdog. See Bus.SetWatchdog.
+type SlowHandler[EData any] struct {
+	Subscriber Subscriber[EData] // Slow subscriber, see Subscriber.Pattern. Zero value for the unhandled sink
+	Event      Event[EData]      // Event being handled, see Event.Topic
+	Elapsed    time.Duration     // Time since handler started
+}
+
+func (d *delivery[EData]) Run() {
+	pe := d.handle()
+	reported := pe != nil && d.reportPanic(pe)
+	d.bus.inflight.done()
+
+	if d.finished != nil {
+		close(d.finished)
+		return
+	}
+	if pe != nil && !reported {
+		// Recovered panic lost handler's frames, so print them before crashing.
+		fmt.Fprintf(panicOutput, "gogoevents: handler panicked: %v\n\n%s\n", pe.Value, pe.Stack)
+		panic(pe.Value)
+	}
+}
+
+// Reports recovered panic, see Bus.SetPanicHandler. Kept apart from Run, so that handler goroutines
+// don't need stack for the event copy.
+func (d *delivery[EData]) reportPanic(pe *PanicError) bool {
+	return d.bus.reportPanic(pe, d.sub, d.event())
+}
+
+func (d *delivery[EData]) Drop(reason error) {
+	if reason != errUnsubscribed {
+		d.fail(ReasonDropped, reason)
+	}
+	d.ev.Done()
+	d.bus.inflight.done()
+	if d.finished != nil {
+		close(d.finished)
+	}
+}
+
+// Calls the handler, unless event's context is done, retrying if subscriber has retry policy,
+// and marks the event done. If handler timeout is set and expires, the event is marked done
+// and reported to watchdog immediately, though the handler keeps running until it returns.
+// Handler failure is recorded to the event's Result. Recovered panic is also returned.
+func (d *delivery[EData]) handle() *PanicError {
+	if d.ev.ctx.Err() != nil {
+		d.ev.Done()
+		return nil
+	}
+	if d.bus.tracer != nil {
+		return d.runTraced()
+	}
+	pe, _ := d.run()
+	return pe
+}
+
+// Same as run, within handler span. Kept apart, so that handler goroutines don't need more stack without tracer.
+func (d *delivery[EData]) runTraced() *PanicError {
+	span := d.traceHandle()
+	pe, err := d.run()
+	if pe != nil {
+		span.End(pe)
+	} else {
+		span.End(err)
+	}
+	return pe
+}
+
+// Runs the handler, see handle. Returns recovered panic, or handler failure.
+func (d *delivery[EData]) run() (pe *PanicError, err error) {
+	d.bus.inflight.running.Add(1)
+	defer d.bus.inflight.running.Add(-1)
+
+	if timeout := d.timeout(); timeout > 0 {
+		ctx, cancel := context.WithTimeoutCause(d.ev.ctx, timeout, ErrHandlerTimeout)
+		defer cancel()
+		d.ev.ctx = ctx
+
+		start := time.Now()
+		t := time.AfterFunc(timeout, func() { d.expire(start) })
+		defer t.Stop()
+	}
+
+	if m := d.bus.metrics; m != nil {
+		m.HandlerStarted(d.sub.pattern)
+		start := time.Now()
+		defer func() {
+			failure := err
+			if pe != nil {
+				m.Panicked(d.sub.pattern)
+				failure = pe
+			}
+			m.HandlerFinished(d.sub.pattern, time.Since(start), failure)
+		}()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			pe = &PanicError{Value: r, Stack: debug.Stack()}
+			d.finish(ReasonPanicked, pe)
+		}
+		d.ev.Done()
+	}()
+
+	err = d.call()
+	d.finish(ReasonFailed, err)
+	return nil, err
+}
+
+// Calls the handler, retrying according to subscriber's retry policy. Returns the last error.
+func (d *delivery[EData]) call() error {
+	ev := d.ev
+	handler := d.handler()
+	for {
+		ev.attempt = int(d.attempts.Add(1))
+
+		err := handler(ev)
+		if err == nil || d.sub.retry == nil {
+			return err
+		}
+		delay, ok := d.sub.retry.next(ev.attempt, err)
+		if !ok || !sleepContext(ev.ctx, delay) {
+			return err
+		}
+	}
+}
+
+// Completes running delivery, recording its failure, if any.
+// Does nothing if delivery has already expired.
+func (d *delivery[EData]) finish(reason DeadLetterReason, err error) {
+	if d.state.CompareAndSwap(deliveryRunning, deliveryFinished) && err != nil {
+		d.fail(reason, err)
+	}
+}
+
+// Expires running delivery on timeout. Does nothing if delivery has already finished.
+func (d *delivery[EData]) expire(start time.Time) {
+	if !d.state.CompareAndSwap(deliveryRunning, deliveryExpired) {
+		return
+	}
+	elapsed := time.Since(start)
+	d.fail(ReasonTimedOut, ErrHandlerTimeout)
+	d.ev.Done()
+	d.bus.reportSlow(SlowHandler[EData]{Subscriber: d.sub, Event: d.event(), Elapsed: elapsed})
+}
+
+// Returns handler timeout: subscriber's own, or bus-wide.
+func (d *delivery[EData]) timeout() time.Duration {
+	if d.sub.timeout > 0 {
+		return d.sub.timeout
+	}
+	return d.bus.handlerTimeout
+}
+
+// Returns event with current attempt number.
+func (d *delivery[EData]) event() Event[EData] {
+	ev := d.ev
+	ev.attempt = int(d.attempts.Load())
+	return ev
+}
+
+// Records delivery failure to the event's Result and sends it to dead letters.
+func (d *delivery[EData]) fail(reason DeadLetterReason, err error) {
+	d.ev.res.addErr(&HandlerError{SubscriberID: d.sub.id, Pattern: d.sub.pattern, Topic: d.ev.Topic, Err: err})
+	d.bus.deadLetter(d.deadLetter(reason, err))
+}
+
+func (d *delivery[EData]) deadLetter(reason DeadLetterReason, err error) DeadLetter[EData] {
+	return DeadLetter[EData]{Event: d.event(), Subscriber: d.sub, Reason: reason, Err: err, Attempts: int(d.attempts.Load())}
+}
@@ -0,0 +1,173 @@
+/*
+ * Holds event delivery dispatchers
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"errors"
+	"sync"
+)
+
+// Single unit of work scheduled by Dispatcher - delivery of an event to one handler.
+type Task interface {
+	// Delivers the event.
+	Run()
+	// Discards the task without delivering the event. `reason` tells why.
+	Drop(reason error)
+}
+
+// Schedules event deliveries for execution. Dispatch is called by Publish, possibly concurrently.
+//   - Every task accepted by Dispatch must eventually be either run or dropped, exactly once.
+//   - If Dispatch returns error, task is considered rejected and must be neither run nor dropped.
+//     The error is returned from Publish.
+type Dispatcher interface {
+	Dispatch(t Task) error
+}
+
+// Runs every task in its own goroutine. This is the default dispatcher.
+type GoDispatcher struct{}
+
+func (GoDispatcher) Dispatch(t Task) error {
+	go t.Run()
+	return nil
+}
+
+// What to do with a new task when the queue is full.
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // Wait for free space in queue
+	OverflowDropNewest                       // Drop the new task
+	OverflowDropOldest                       // Drop the oldest queued task to free space for the new one
+	OverflowError                            // Reject the new task with `ErrQueueFull`
+)
+
+var (
+	ErrQueueFull        = errors.New("queue is full")
+	ErrDispatcherClosed = errors.New("dispatcher is closed")
+)
+
+// Dispatcher with a fixed number of worker goroutines and a bounded queue.
+type WorkerPool struct {
+	queue   chan Task
+	quit    chan struct{} // Closed by stop
+	policy  OverflowPolicy
+	wg      sync.WaitGroup
+	senders sync.WaitGroup // Dispatch calls in progress, queue is closed once they're done after stop
+	mu      sync.RWMutex   // Never held while blocked, so that tasks may stop the pool
+	closed  bool
+}
+
+// Starts `workers` goroutines, fed by a queue holding up to `queueSize` tasks.
+// `policy` is applied when the queue is full.
+// Call Close to stop the pool.
+func NewWorkerPool(workers, queueSize int, policy OverflowPolicy) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &WorkerPool{queue: make(chan Task, queueSize), quit: make(chan struct{}), policy: policy}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for t := range p.queue {
+		t.Run()
+	}
+}
+
+// Queues the task according to the pool's overflow policy.
+// Returns `ErrDispatcherClosed` after Close, `ErrQueueFull` if policy is OverflowError and the queue is full.
+// Dispatch blocked by OverflowBlock policy returns `ErrDispatcherClosed` once the pool is closed.
+func (p *WorkerPool) Dispatch(t Task) error {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrDispatcherClosed
+	}
+	p.senders.Add(1)
+	p.mu.RUnlock()
+	defer p.senders.Done()
+
+	select {
+	case p.queue <- t:
+		return nil
+	default:
+	}
+
+	switch p.policy {
+	case OverflowDropNewest:
+		t.Drop(ErrQueueFull)
+		return nil
+	case OverflowDropOldest:
+		for {
+			select {
+			case p.queue <- t:
+				return nil
+			case old := <-p.queue:
+				old.Drop(ErrQueueFull)
+			}
+			// Freed space must go to the new task, not to the next old one.
+			select {
+			case p.queue <- t:
+				return nil
+			default:
+			}
+		}
+	case OverflowError:
+		return ErrQueueFull
+	default:
+		select {
+		case p.queue <- t:
+			return nil
+		case <-p.quit:
+			return ErrDispatcherClosed
+		}
+	}
+}
+
+// Stops accepting new tasks and waits until all queued tasks are run.
+// Safe to call multiple times. Must not be called by a task run by the pool, as it would wait for itself.
+func (p *WorkerPool) Close() error {
+	p.stop()
+	p.wg.Wait()
+	return nil
+}
+
+// Stops accepting new tasks, without waiting. Workers exit after running queued tasks.
+// Safe to call by a task run by the pool.
+func (p *WorkerPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.quit)
+	// Blocked senders give up on quit, so this doesn't wait for workers.
+	go func() {
+		p.senders.Wait()
+		close(p.queue)
+	}()
+}
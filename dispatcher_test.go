@@ -0,0 +1,144 @@
+/*
+ * Holds tests for dispatchers.
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+type testTask struct {
+	run     func()
+	dropped atomic.Bool
+}
+
+func (t *testTask) Run() {
+	if t.run != nil {
+		t.run()
+	}
+}
+
+func (t *testTask) Drop(reason error) {
+	t.dropped.Store(true)
+}
+
+// Returns pool with its single worker blocked until returned func is called, and queue of size 1 filled.
+func blockedPool(t *testing.T, policy OverflowPolicy) (*WorkerPool, *testTask, func()) {
+	t.Helper()
+
+	p := NewWorkerPool(1, 1, policy)
+	started, release := make(chan struct{}), make(chan struct{})
+	if err := p.Dispatch(&testTask{run: func() { close(started); <-release }}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	queued := &testTask{}
+	if err := p.Dispatch(queued); err != nil {
+		t.Fatal(err)
+	}
+	return p, queued, func() { close(release) }
+}
+
+func TestWorkerPoolDropNewest(t *testing.T) {
+	p, queued, release := blockedPool(t, OverflowDropNewest)
+
+	newest := &testTask{}
+	if err := p.Dispatch(newest); err != nil {
+		t.Fatal(err)
+	}
+	if !newest.dropped.Load() || queued.dropped.Load() {
+		t.Fatalf("expected only newest task dropped")
+	}
+
+	release()
+	p.Close()
+}
+
+func TestWorkerPoolDropOldest(t *testing.T) {
+	p, queued, release := blockedPool(t, OverflowDropOldest)
+
+	newest := &testTask{}
+	if err := p.Dispatch(newest); err != nil {
+		t.Fatal(err)
+	}
+	if newest.dropped.Load() || !queued.dropped.Load() {
+		t.Fatalf("expected only oldest task dropped")
+	}
+
+	release()
+	p.Close()
+}
+
+func TestWorkerPoolError(t *testing.T) {
+	p, _, release := blockedPool(t, OverflowError)
+
+	if err := p.Dispatch(&testTask{}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+
+	release()
+	p.Close()
+
+	if err := p.Dispatch(&testTask{}); !errors.Is(err, ErrDispatcherClosed) {
+		t.Fatalf("expected %v, got %v", ErrDispatcherClosed, err)
+	}
+}
+
+func TestPublishWithWorkerPool(t *testing.T) {
+	pool := NewWorkerPool(4, 16, OverflowBlock)
+	eb := NewUntyped(WithDispatcher(pool))
+
+	got := atomic.Int32{}
+	subsCount := int32(100)
+	for i := int32(0); i < subsCount; i++ {
+		eb.Subscribe("test*", func(ev Event[any]) {
+			got.Add(1)
+		})
+	}
+
+	for i := 0; i < 100; i++ {
+		got.Store(0)
+		wg, err := eb.Publish("testevent", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		if gotVal := got.Load(); gotVal != subsCount {
+			t.Fatalf("Expected %d events, got %d", subsCount, gotVal)
+		}
+	}
+
+	eb.Close()
+	pool.Close()
+}
+
+func TestPublishReturnsDispatcherError(t *testing.T) {
+	p, _, release := blockedPool(t, OverflowError)
+	eb := NewUntyped(WithDispatcher(p))
+	eb.Subscribe("test", func(ev Event[any]) {})
+
+	wg, err := eb.Publish("test", nil)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+	wg.Wait() // rejected delivery must be marked done
+
+	release()
+	eb.Close()
+	p.Close()
+}
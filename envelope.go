@@ -0,0 +1,198 @@
+/*
+ * Holds event metadata envelope and publish options
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// Event metadata, set on publish.
+type Envelope struct {
+	ID      string    // Unique event id
+	Time    time.Time // Publish time
+	Source  string    // Publisher, see WithSource and WithEventSource
+	Headers Headers   // Never nil for published events
+
+	// Id of the first event in the causal chain, equal to ID for events not caused by others.
+	CorrelationID string
+	// Id of the event this one was published by the handler of, if any. See CausedBy.
+	CausationID string
+}
+
+// Event headers.
+type Headers map[string]string
+
+// Returns header value, or empty string if there's no such header. Safe to call on nil Headers.
+func (h Headers) Get(key string) string {
+	return h[key]
+}
+
+// Sets header value. Headers must not be nil. Together with Get and Keys,
+// makes Headers a carrier for trace context propagation, see Tracer.
+func (h Headers) Set(key, value string) {
+	h[key] = value
+}
+
+// Returns header keys, in no particular order.
+func (h Headers) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Returns copy of the headers, which is safe to modify.
+func (h Headers) Clone() Headers {
+	if h == nil {
+		return Headers{}
+	}
+	return maps.Clone(h)
+}
+
+// Publish option, see Bus.Publish.
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	id            string
+	source        string
+	headers       Headers
+	correlationID string
+	cause         *cause
+}
+
+func newPublishOptions(opts []PublishOption) publishOptions {
+	o := publishOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Sets event header. Headers are copied on publish.
+func WithHeader(key, value string) PublishOption {
+	return func(o *publishOptions) {
+		if o.headers == nil {
+			o.headers = Headers{}
+		}
+		o.headers[key] = value
+	}
+}
+
+// Sets event headers, in addition to ones set by other options. Headers are copied on publish.
+func WithHeaders(headers Headers) PublishOption {
+	return func(o *publishOptions) {
+		if o.headers == nil {
+			o.headers = make(Headers, len(headers))
+		}
+		maps.Copy(o.headers, headers)
+	}
+}
+
+// Sets event id instead of generated one, e.g. to keep id of an event received from outside.
+// It's up to the caller to keep ids unique.
+func WithEventID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.id = id
+	}
+}
+
+// Overrides bus-wide source of the event, see WithSource.
+func WithEventSource(source string) PublishOption {
+	return func(o *publishOptions) {
+		o.source = source
+	}
+}
+
+// Sets correlation id of the event, e.g. to continue a chain started outside of the bus.
+// Overrides the one inherited from the causing event.
+func WithCorrelationID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.correlationID = id
+	}
+}
+
+// Marks the event as caused by handled event `ev`, typically by the handler publishing it:
+// the event gets causation and correlation ids of `ev` (see Envelope), and values of its context
+// (see Event.Context), e.g. the trace context (see Tracer). Values of the context event is published with
+// take precedence. Cancellation of `ev` context isn't inherited, so events published by a handler
+// are delivered even after it returns or times out. No-op for the zero Event.
+func CausedBy[EData any](ev Event[EData]) PublishOption {
+	return func(o *publishOptions) {
+		if ev.ID == "" {
+			o.cause = nil
+			return
+		}
+		o.cause = &cause{id: ev.ID, correlationID: ev.CorrelationID, ctx: ev.ctx}
+	}
+}
+
+// Causing event, see CausedBy.
+type cause struct {
+	id, correlationID string
+	ctx               context.Context // Causing event's context, may be nil
+}
+
+// Context with cancellation of one context and values of another one too, see CausedBy.
+type causedContext struct {
+	context.Context
+	values context.Context // Without cancellation, so its cancellation cause doesn't leak
+}
+
+func (c causedContext) Value(key any) any {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.values.Value(key)
+}
+
+// Returns envelope of the event to be published with `ctx`, and the context the event carries,
+// see CausedBy.
+func (b *Bus[EData]) newEnvelope(ctx context.Context, opts []PublishOption) (Envelope, context.Context) {
+	o := newPublishOptions(opts)
+	env := Envelope{
+		ID:            o.id,
+		Time:          time.Now(),
+		Source:        o.source,
+		Headers:       o.headers,
+		CorrelationID: o.correlationID,
+	}
+	if env.Headers == nil {
+		env.Headers = Headers{}
+	}
+	if env.ID == "" {
+		env.ID = newEventID()
+	}
+	if env.Source == "" {
+		env.Source = b.source
+	}
+
+	if c := o.cause; c != nil {
+		env.CausationID = c.id
+		if env.CorrelationID == "" {
+			env.CorrelationID = c.correlationID
+		}
+		if c.ctx != nil {
+			ctx = causedContext{Context: ctx, values: context.WithoutCancel(c.ctx)}
+		}
+	}
+	if env.CorrelationID == "" {
+		env.CorrelationID = env.ID
+	}
+	return env, ctx
+}
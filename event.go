@@ -16,16 +16,42 @@
 package gogoevents
 
 import (
-	"sync"
+	"context"
 	"sync/atomic"
 )
 
 // Event. Don't pass by reference.
 type Event[EData any] struct {
+	Envelope // Zero for events not published by the bus. Headers are shared by all handlers, so must not be modified
+
+	ctx   context.Context
 	done  *atomic.Bool
 	Data  *EData
-	wg    *sync.WaitGroup
+	res   *Result
+	inbox *inbox[EData] // Set for requests, see Bus.Request
 	Topic string
+
+	attempt int
+}
+
+// Returns the context event was published with.
+// For events published without context, it's context.Background().
+// It's cancelled once handler times out (see WithTimeout), so use CausedBy to publish follow-up events.
+func (ev *Event[EData]) Context() context.Context {
+	if ev.ctx == nil {
+		return context.Background()
+	}
+	return ev.ctx
+}
+
+// Takes fields publish middleware may change from `other`, see PublishMiddleware.
+func (ev *Event[EData]) take(other *Event[EData]) {
+	ev.Envelope, ev.Topic, ev.Data = other.Envelope, other.Topic, other.Data
+}
+
+// Returns handling attempt number, starting with 1. Greater than 1 only for retries, see WithRetry.
+func (ev *Event[EData]) Attempt() int {
+	return max(ev.attempt, 1)
 }
 
 // If you have code waiting for Publish to process events,
@@ -34,8 +60,9 @@ type Event[EData any] struct {
 //   - It's safe although with no effect to call Done after handler returns (e.g. in goroutine).
 //   - It's safe to call Done() multiple times and from different Goroutines but try not to hold event objects
 //     longer than their expected lifetime
+//   - It has no effect in subscription filters, see Subscription.Filter.
 func (ev *Event[EData]) Done() {
-	if ev.done.CompareAndSwap(false, true) {
-		ev.wg.Done()
+	if ev.done != nil && ev.done.CompareAndSwap(false, true) {
+		ev.res.wg.Done()
 	}
 }
@@ -16,175 +16,619 @@
 package gogoevents
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/amanofbits/gogoevents/internal/wildcard"
+	"github.com/amanofbits/gogoevents/internal/cache"
+	"github.com/amanofbits/gogoevents/internal/radix"
 )
 
+// Bus is safe for concurrent use. Publishers never lock: they read an immutable snapshot of bus state,
+// which (un)subscribing and setting hooks replace atomically.
 type Bus[EData any] struct {
-	unhandledSink func(Event[EData])
-	topicCache    map[string][]uint32
-	patterns      []string
-	subs          []Subscriber[EData]
-	mu            sync.RWMutex
+	state          atomic.Pointer[snapshot[EData]]
+	topicCache     *cache.Clock[[]Subscriber[EData]] // Matched subscribers by topic
+	subs           map[uint64]Subscriber[EData]      // Subscribers by id
+	dispatcher     Dispatcher
+	syntax         syntax
+	handlerTimeout time.Duration
+	requestTimeout time.Duration
+	source         string
+	metrics        Metrics      // Nil if not measured
+	log            *eventLogger // Nil if not logging
+	tracer         Tracer       // Nil if not tracing
+	inflight       inflight
+	closed         chan struct{} // Closed once the bus is closed
+	mu             sync.Mutex    // Serializes state changes
 }
 
-func NewUntyped() *Bus[any] {
-	return New[any]()
+// Bus state read by publishers. Never modified once stored, see Bus.update.
+type snapshot[EData any] struct {
+	index          radix.Tree[Subscriber[EData]] // Subscribers by pattern's literal prefix
+	regexps        radix.Tree[Subscriber[EData]] // Regexp subscribers, kept apart as their patterns may equal others
+	unhandledSink  func(Event[EData]) error
+	panicHandler   func(HandlerPanic[EData])
+	deadLetterSink func(DeadLetter[EData])
+	watchdog       func(SlowHandler[EData])
+	middleware     middleware[EData] // Bus-wide middleware
+	closed         bool
 }
 
-func New[EData any]() *Bus[EData] {
-	return &Bus[EData]{topicCache: make(map[string][]uint32)}
+func NewUntyped(opts ...Option) *Bus[any] {
+	return New[any](opts...)
 }
 
-func (b *Bus[EData]) Close() error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	clear(b.topicCache)
-	b.patterns = b.patterns[:0]
-	b.subs = b.subs[:0]
-	b.unhandledSink = nil
-	return nil
+func New[EData any](opts ...Option) *Bus[EData] {
+	o := newOptions(opts)
+	b := &Bus[EData]{
+		topicCache:     cache.NewClock[[]Subscriber[EData]](o.topicCacheSize),
+		subs:           make(map[uint64]Subscriber[EData]),
+		dispatcher:     o.dispatcher,
+		syntax:         o.syntax,
+		handlerTimeout: o.handlerTimeout,
+		requestTimeout: o.requestTimeout,
+		source:         o.source,
+		metrics:        o.metrics,
+		log:            newEventLogger(o.logger, o.logSampling),
+		tracer:         o.tracer,
+		inflight:       inflight{drained: make(chan struct{})},
+		closed:         make(chan struct{}),
+	}
+	b.state.Store(&snapshot[EData]{})
+	return b
 }
 
 func (b *Bus[EData]) TotalSubscribers() int {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+	s := b.state.Load()
+	return s.index.Len() + s.regexps.Len()
+}
 
-	return len(b.patterns)
+// Returns index the subscriber belongs to.
+func (s *snapshot[EData]) indexOf(sub Subscriber[EData]) *radix.Tree[Subscriber[EData]] {
+	if _, ok := sub.matcher.(regexpMatcher); ok {
+		return &s.regexps
+	}
+	return &s.index
 }
 
-var ErrIllegalWildcard = errors.New("wildcards not allowed in topic")
+// Replaces bus state with its copy modified by `fn`. Returns false and changes nothing if the bus is closed.
+// Must be called under lock.
+func (b *Bus[EData]) update(fn func(s *snapshot[EData])) bool {
+	s := *b.state.Load()
+	if s.closed {
+		return false
+	}
+	fn(&s)
+	b.state.Store(&s)
+	return true
+}
+
+var (
+	ErrIllegalWildcard = errors.New("wildcards not allowed in topic")
+	ErrInvalidPattern  = errors.New("invalid pattern")
+	ErrInvalidLimit    = errors.New("deliveries limit must be positive")
+)
+
+// Publishes event asynchronously and returns a Result that can be used to wait while all events are dispatched,
+// and to get handler failures.
+// Handlers are run by bus' Dispatcher (see WithDispatcher), by default each in its own goroutine.
+// Returns `ErrIllegalWildcard` if wildcard is found in hierarchical `topic` (see WithHierarchy),
+// `ErrClosed` if the bus is closed.
+// Returns error from Dispatcher if it rejects some delivery, e.g. `ErrQueueFull`.
+// The Result is valid in that case, and rejected deliveries are marked done.
+// See PublishOption for available options, and Envelope for event metadata.
+// Event passes through publish middleware first, see PublishMiddleware.
+func (b *Bus[EData]) Publish(topic string, data EData, opts ...PublishOption) (*Result, error) {
+	return b.PublishContext(context.Background(), topic, data, opts...)
+}
 
-// Publishes event asynchronously and returns a WaitGroup that can be used to wait while all events are dispatched.
-// Returns `ErrIllegalWildcard` if wildcard is found in the `topic`. No other errors.
-func (b *Bus[EData]) Publish(topic string, data EData) (*sync.WaitGroup, error) {
-	if wci := wildcard.Index(topic); wci >= 0 {
-		return nil, fmt.Errorf("%s, at %d: %w", topic, wci, ErrIllegalWildcard)
+// Same as Publish, but the event carries `ctx`, available to handlers via Event.Context().
+// Once `ctx` is cancelled, handlers that haven't started yet are skipped (but still marked done).
+// Returns `ctx.Err()` if `ctx` is already done, nothing is dispatched then.
+// See Result.WaitContext to wait for handlers with respect to a context.
+// To publish event caused by the handled one, see CausedBy.
+func (b *Bus[EData]) PublishContext(ctx context.Context, topic string, data EData, opts ...PublishOption) (*Result, error) {
+	return b.publish(ctx, topic, data, nil, opts)
+}
+
+// Publishes event, which is a request if `in` isn't nil. See PublishContext.
+func (b *Bus[EData]) publish(ctx context.Context, topic string, data EData, in *inbox[EData], opts []PublishOption) (res *Result, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	wg := sync.WaitGroup{}
-	ev := Event[EData]{Topic: topic, Data: &data, wg: &wg}
+	env, ctx := b.newEnvelope(ctx, opts)
+	ev := Event[EData]{Envelope: env, Topic: topic, Data: &data, ctx: ctx, inbox: in}
+	if b.tracer != nil {
+		span := b.tracePublish(&ev)
+		defer func() { span.End(err) }()
+	}
+	mws := b.state.Load().middleware.publish
+	if len(mws) == 0 {
+		return b.publishEvent(ev)
+	}
 
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+	res, err = intercept(mws, ev, b.publishEvent)
+	if res == nil && err == nil {
+		// Dropped by middleware.
+		res = &Result{}
+	}
+	return res, err
+}
+
+// Matches and dispatches event that passed bus-wide publish middleware. See Publish.
+func (b *Bus[EData]) publishEvent(ev Event[EData]) (*Result, error) {
+	if wci := b.syntax.wildcardIndex(ev.Topic); wci >= 0 {
+		return nil, fmt.Errorf("%s, at %d: %w", ev.Topic, wci, ErrIllegalWildcard)
+	}
+
+	res := &Result{}
+	ev.res = res
+
+	subs, spent, err := b.matchSubscribers(ev)
+	if err != nil {
+		return nil, err
+	}
+	defer b.unsubscribeSpent(spent)
+
+	res.wg.Add(len(subs))
+
+	deliveries := make([]delivery[EData], len(subs))
+	for i := 0; i < len(subs); i++ {
+		d := &deliveries[i]
+		d.bus, d.sub, d.ev = b, subs[i], ev
+		d.ev.done = &d.done
 
-	if len(b.patterns) == 0 {
-		wg.Add(0)
-		if b.unhandledSink != nil {
-			wg.Add(1)
-			go handle(b.unhandledSink, ev, &atomic.Bool{})
+		sent, dErr := b.send(d, false)
+		if !sent {
+			if dErr != nil {
+				b.deadLetter(d.deadLetter(ReasonDropped, dErr))
+			}
+			d.ev.Done()
+			b.inflight.done()
+		}
+		if dErr != nil && err == nil {
+			err = fmt.Errorf("%s: %w", ev.Topic, dErr)
 		}
-		return &wg, nil
 	}
+	return res, err
+}
 
-	indices, ok := b.topicCache[topic]
-	if !ok {
-		matched := false
-		indices = make([]uint32, 0, 4)
+// Publishes event synchronously: matched handlers (or the unhandled sink) are called one after another
+// on the caller's goroutine, in subscribers' order, and PublishSync returns when all of them have returned.
+// Returns handler failures the same way as Result.Wait does.
+// Handler panics are recovered and returned as `*PanicError` (wrapped into `*HandlerError`).
+// Remaining handlers are still called after a panic. Panics are reported to the panic handler too,
+// but never re-panicked, see SetPanicHandler.
+// Returns `ErrIllegalWildcard` if wildcard is found in hierarchical `topic` (see WithHierarchy),
+// `ErrClosed` if the bus is closed.
+//
+// The exception are subscribers with mailbox (see WithMailbox): to keep their order,
+// event is queued to the mailbox as usual and PublishSync waits for its delivery.
+// So, it must not be called from such subscriber's handler for a topic the subscriber matches.
+func (b *Bus[EData]) PublishSync(topic string, data EData, opts ...PublishOption) error {
+	return b.PublishSyncContext(context.Background(), topic, data, opts...)
+}
+
+// Same as PublishSync, but with the context. See PublishContext for context handling.
+func (b *Bus[EData]) PublishSyncContext(ctx context.Context, topic string, data EData, opts ...PublishOption) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	env, ctx := b.newEnvelope(ctx, opts)
+	ev := Event[EData]{Envelope: env, Topic: topic, Data: &data, ctx: ctx}
+	if b.tracer != nil {
+		span := b.tracePublish(&ev)
+		defer func() { span.End(err) }()
+	}
 
-		if wildcard.Match(b.patterns[0], topic) {
-			indices = append(indices, 0)
-			matched = true
+	var res *Result
+	if mws := b.state.Load().middleware.publish; len(mws) == 0 {
+		res, err = b.publishEventSync(ev)
+	} else {
+		res, err = intercept(mws, ev, b.publishEventSync)
+	}
+	if err != nil || res == nil {
+		return err
+	}
+	return res.Err()
+}
+
+// Matches event that passed bus-wide publish middleware and runs its handlers. See PublishSync.
+func (b *Bus[EData]) publishEventSync(ev Event[EData]) (*Result, error) {
+	if wci := b.syntax.wildcardIndex(ev.Topic); wci >= 0 {
+		return nil, fmt.Errorf("%s, at %d: %w", ev.Topic, wci, ErrIllegalWildcard)
+	}
+
+	res := &Result{}
+	ev.res = res
+
+	subs, spent, err := b.matchSubscribers(ev)
+	if err != nil {
+		return nil, err
+	}
+	defer b.unsubscribeSpent(spent)
+
+	res.wg.Add(len(subs))
+
+	for i := 0; i < len(subs); i++ {
+		d := &delivery[EData]{bus: b, sub: subs[i], ev: ev}
+		d.ev.done = &d.done
+		if subs[i].mailbox != nil {
+			d.finished = make(chan struct{})
 		}
 
-		for i := 1; i < len(b.patterns); i++ {
-			// A - sliightly slower
-			// if b.patterns[i] != b.patterns[i-1] {
-			// 	matched = wildcard.Match(b.patterns[i], topic)
-			// }
-			// if matched {
-			// 	b.indices = append(b.indices, uint32(i))
-			// }
-
-			// B - sliightly faster
-			matchValid := b.patterns[i] == b.patterns[i-1]
-			if (matchValid && matched) ||
-				(!matchValid && wildcard.Match(b.patterns[i], topic)) {
-				indices = append(indices, uint32(i))
-			}
+		sent, err := b.send(d, true)
+		switch {
+		case !sent && err != nil:
+			d.fail(ReasonDropped, err)
+			fallthrough
+		case !sent:
+			d.ev.Done()
+			b.inflight.done()
+			continue
+		case err != nil:
+			res.addErr(&HandlerError{SubscriberID: d.sub.id, Pattern: d.sub.pattern, Topic: d.ev.Topic, Err: err})
+		}
+		if d.finished != nil {
+			<-d.finished
 		}
-		b.topicCache[topic] = indices
 	}
-	wg.Add(len(indices))
+	return res, nil
+}
+
+// Returns subscribers matching the event's topic and accepting the event,
+// or unhandled sink disguised as subscriber if there are none.
+// Returned slice must not be modified. Returned subscribers are counted as in-flight deliveries, so each of them must be completed.
+// `spent` are subscribers that have just got their last delivery (see SubscribeN), to be unsubscribed after dispatch.
+// Returns `ErrClosed` if the bus is closed.
+func (b *Bus[EData]) matchSubscribers(ev Event[EData]) (subs, spent []Subscriber[EData], err error) {
+	if b.tracer != nil {
+		span := b.traceMatch(ev)
+		defer func() { span.End(err) }()
+	}
+
+	s := b.state.Load()
+	if s.closed {
+		return nil, nil, ErrClosed
+	}
+
+	subs, spent = accepting(b.match(s, ev.Topic), ev)
+	unhandled := len(subs) == 0
+	if ev.inbox != nil {
+		ev.inbox.responders = len(subs)
+	}
+	if unhandled && s.unhandledSink != nil {
+		subs = []Subscriber[EData]{{handler: s.unhandledSink}}
+	}
 
-	dones := make([]atomic.Bool, len(indices))
+	// Counted before checking again, so that either Shutdown waits for these deliveries,
+	// or they are never dispatched.
+	b.inflight.add(len(subs))
+	if b.state.Load().closed {
+		b.inflight.release(len(subs))
+		return nil, nil, ErrClosed
+	}
 
-	for i := 0; i < len(indices); i++ {
-		go handle(b.subs[indices[i]].handler, ev, &dones[i])
+	if b.metrics != nil {
+		b.metrics.Published(ev.Topic)
+		if unhandled {
+			b.metrics.Unhandled(ev.Topic)
+		}
 	}
-	if b.unhandledSink != nil && len(indices) == 0 {
-		go handle(b.unhandledSink, ev, &atomic.Bool{})
+	if unhandled {
+		b.logUnhandled(ev)
 	}
-	return &wg, nil
+	return subs, spent, nil
 }
 
-func handle[EData any](handler func(Event[EData]), ev Event[EData], done *atomic.Bool) {
-	ev.done = done
-	handler(ev)
-	ev.Done()
+// Returns subscribers whose filters accept the event and who have deliveries left, taking one of them.
+// Copies `subs` only if some are rejected. `spent` are subscribers that took their last delivery.
+func accepting[EData any](subs []Subscriber[EData], ev Event[EData]) (accepted, spent []Subscriber[EData]) {
+	accepted = subs
+	copied := false
+	for i, sub := range subs {
+		ok := sub.filter == nil || sub.filter(ev)
+		if ok && sub.left != nil {
+			left := sub.left.Add(-1)
+			ok = left >= 0
+			if left == 0 {
+				spent = append(spent, sub)
+			}
+		}
+
+		switch {
+		case !ok && !copied:
+			accepted, copied = subs[:i:i], true
+		case ok && copied:
+			accepted = append(accepted, sub)
+		}
+	}
+	return accepted, spent
 }
 
-func (b *Bus[EData]) Subscribe(pattern string, handler func(ev Event[EData])) Subscriber[EData] {
-	pattern = wildcard.Normalize(pattern)
+// Removes subscribers that got their last delivery.
+func (b *Bus[EData]) unsubscribeSpent(spent []Subscriber[EData]) {
+	for _, sub := range spent {
+		b.Unsubscribe(sub)
+	}
+}
 
-	b.mu.Lock()
-	defer b.mu.Unlock()
+// Hands delivery over to subscriber's mailbox, if any, or to bus' dispatcher.
+func (b *Bus[EData]) dispatch(d *delivery[EData]) error {
+	if d.sub.mailbox == nil {
+		return b.dispatcher.Dispatch(d)
+	}
+	if err := d.sub.mailbox.Dispatch(d); err != ErrDispatcherClosed {
+		return err
+	}
+	// Subscriber was unsubscribed after it was matched.
+	d.Drop(errUnsubscribed)
+	return nil
+}
 
-	clear(b.topicCache)
+// Runs delivery's handler on the caller's goroutine, or hands delivery over to subscriber's mailbox. See PublishSync.
+func (b *Bus[EData]) dispatchSync(d *delivery[EData]) error {
+	if d.sub.mailbox != nil {
+		return b.dispatch(d)
+	}
+	if pe := d.handle(); pe != nil {
+		b.reportPanic(pe, d.sub, d.event())
+	}
+	b.inflight.done()
+	return nil
+}
+
+// Returns subscribers matching the `topic`, sorted by pattern, then by subscription order.
+// Returned slice is cached and must not be modified.
+func (b *Bus[EData]) match(s *snapshot[EData], topic string) []Subscriber[EData] {
+	if s.index.Len() == 0 && s.regexps.Len() == 0 {
+		return nil
+	}
 
-	pos := -1
-	pos, _ = binarySearchFunc(b.patterns, pattern, func(el, trgt string, idx int) int {
-		if el > trgt {
-			return 1
+	subs, ok := b.topicCache.Get(topic)
+	if b.metrics != nil {
+		b.metrics.CacheLookup(ok)
+	}
+	if ok {
+		return subs
+	}
+
+	collect := func(pattern string, group []Subscriber[EData], exact bool) {
+		// Subscribers of a group share the pattern.
+		if exact || group[0].matcher.Match(topic) {
+			subs = append(subs, group...)
 		}
-		if pos < idx {
-			pos = idx
-			return -1
+	}
+	s.index.Walk(topic, collect)
+	s.regexps.Walk(topic, collect)
+	slices.SortFunc(subs, func(a, b Subscriber[EData]) int {
+		if c := strings.Compare(a.pattern, b.pattern); c != 0 {
+			return c
 		}
-		return 0
+		return cmp.Compare(a.id, b.id)
 	})
 
-	// Below code works because items are stored by value. Spares few ns.
-	b.patterns = shift(b.patterns, pos)
-	b.patterns[pos] = pattern
+	// Matches of a stale snapshot are not cached, as its invalidation may be already done.
+	b.topicCache.Put(topic, subs, func() bool { return b.state.Load() == s })
+	return subs
+}
+
+// Drops cached matches of topics affected by subscription change of the pattern `m`.
+// Must be called under lock, after the change.
+func (b *Bus[EData]) invalidate(m matcher) {
+	if topic, exact := m.LiteralPrefix(); exact {
+		b.topicCache.Delete(topic)
+		return
+	}
+	b.topicCache.DeleteFunc(func(topic string, _ []Subscriber[EData]) bool {
+		return m.Match(topic)
+	})
+}
+
+// Topic match cache statistics, see WithTopicCacheSize.
+type CacheStats struct {
+	Hits      uint64 // Publishes served from cache
+	Misses    uint64 // Publishes that had to match subscriptions
+	Evictions uint64 // Entries evicted to make room for new ones
+	Len       int    // Number of cached topics
+	Capacity  int
+}
+
+func (b *Bus[EData]) CacheStats() CacheStats {
+	return CacheStats(b.topicCache.Stats())
+}
+
+// Subscribes `handler` to topics matching the `pattern`. See SubscribeOption for available options,
+// and SubscribeWith for filtering and middleware.
+// Pattern is a glob: '*' matches any characters, '?' matches one character, "[a-z]" matches one character
+// from the class, "{a,b}" matches any of alternatives, and '\' escapes the next character.
+// See WithHierarchy for the other syntax.
+// Returns `ErrInvalidPattern` if pattern is malformed, `ErrClosed` if the bus is closed.
+func (b *Bus[EData]) Subscribe(pattern string, handler func(ev Event[EData]), opts ...SubscribeOption) (Subscriber[EData], error) {
+	return b.SubscribeE(pattern, func(ev Event[EData]) error {
+		handler(ev)
+		return nil
+	}, opts...)
+}
+
+// Same as Subscribe, but `handler` can report failure.
+// Returned error is tagged with subscriber and topic, and is available from Result.Wait.
+func (b *Bus[EData]) SubscribeE(pattern string, handler func(ev Event[EData]) error, opts ...SubscribeOption) (Subscriber[EData], error) {
+	m, err := b.syntax.compile(pattern)
+	if err != nil {
+		return Subscriber[EData]{}, fmt.Errorf("%w %q: %v", ErrInvalidPattern, pattern, err)
+	}
+	return b.subscribe(m, Subscription[EData]{}, handler, opts)
+}
+
+// Same as SubscribeE, with settings of `s`.
+func (b *Bus[EData]) SubscribeWith(pattern string, s Subscription[EData], handler func(ev Event[EData]) error, opts ...SubscribeOption) (Subscriber[EData], error) {
+	m, err := b.syntax.compile(pattern)
+	if err != nil {
+		return Subscriber[EData]{}, fmt.Errorf("%w %q: %v", ErrInvalidPattern, pattern, err)
+	}
+	return b.subscribe(m, s, handler, opts)
+}
+
+// Same as Subscribe, but the subscriber is removed after the first delivery. See SubscribeN.
+func (b *Bus[EData]) SubscribeOnce(pattern string, handler func(ev Event[EData]), opts ...SubscribeOption) (Subscriber[EData], error) {
+	return b.SubscribeN(pattern, 1, handler, opts...)
+}
+
+// Same as Subscribe, but the subscriber is removed after `n` deliveries, even if some of them failed.
+// Deliveries are counted when events are dispatched, so concurrent publishes never exceed `n`.
+// Events rejected by filter (see Subscription.Filter) don't count. Subscriber may be unsubscribed earlier as usual.
+// Returns `ErrInvalidLimit` if `n` isn't positive. Same as Subscribe with WithLimit.
+func (b *Bus[EData]) SubscribeN(pattern string, n int, handler func(ev Event[EData]), opts ...SubscribeOption) (Subscriber[EData], error) {
+	return b.Subscribe(pattern, handler, append(opts[:len(opts):len(opts)], WithLimit(n))...)
+}
+
+func (b *Bus[EData]) subscribe(m matcher, s Subscription[EData], handler func(ev Event[EData]) error, opts []SubscribeOption) (Subscriber[EData], error) {
+	o := newSubscribeOptions(opts)
+	if o.limit != nil && *o.limit <= 0 {
+		return Subscriber[EData]{}, fmt.Errorf("%w: %d", ErrInvalidLimit, *o.limit)
+	}
+
+	sub := Subscriber[EData]{
+		handler:    handler,
+		id:         newUniqueId(),
+		pattern:    m.String(),
+		matcher:    m,
+		filter:     s.Filter,
+		middleware: newMiddleware(s),
+		retry:      o.retry,
+		timeout:    o.timeout,
+	}
+	if o.limit != nil {
+		sub.left = &atomic.Int64{}
+		sub.left.Store(int64(*o.limit))
+	}
+	prefix, exact := m.LiteralPrefix()
+
+	b.mu.Lock()
 
-	b.subs = shift(b.subs, pos)
-	b.subs[pos].handler = handler
-	b.subs[pos].id = newUniqueId()
+	if b.state.Load().closed {
+		b.mu.Unlock()
+		return Subscriber[EData]{}, ErrClosed
+	}
+	if o.ordered {
+		sub.mailbox = NewWorkerPool(1, o.mailboxSize, o.mailboxPolicy)
+	}
+
+	b.update(func(s *snapshot[EData]) {
+		index := s.indexOf(sub)
+		*index = index.Add(prefix, sub.pattern, exact, sub)
+	})
+	b.subs[sub.id] = sub
+	b.invalidate(m)
+	b.mu.Unlock()
 
-	return b.subs[pos]
+	// Outside the lock, as log handler may call the bus.
+	b.logSubscription("subscribed", sub)
+
+	return sub, nil
 }
 
 // Removes specified subscriber from subscriptions.
+// If subscriber has a mailbox, events already queued there are still delivered.
 func (b *Bus[EData]) Unsubscribe(sub Subscriber[EData]) bool {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 
-	idx := 0
-	for ; idx < len(b.subs); idx++ {
-		if b.subs[idx].id == sub.id {
-			break
+	sub, ok := b.subs[sub.id]
+	if !ok {
+		b.mu.Unlock()
+		return false
+	}
+	prefix, exact := sub.matcher.LiteralPrefix()
+	b.update(func(s *snapshot[EData]) {
+		index := s.indexOf(sub)
+		*index, _ = index.Remove(prefix, sub.pattern, exact, func(other Subscriber[EData]) bool {
+			return other.id == sub.id
+		})
+	})
+	delete(b.subs, sub.id)
+	b.invalidate(sub.matcher)
+	b.mu.Unlock()
+
+	b.logSubscription("unsubscribed", sub)
+
+	if sub.mailbox != nil {
+		sub.mailbox.stop()
+	}
+	return true
+}
+
+// Registers sink for events that are published but have no subscribers at the time,
+// or whose subscribers' filters all rejected them (see Subscription.Filter).
+// Simply set to nil to unregister. No-op if the bus is closed.
+func (b *Bus[EData]) SetUnhandledSink(sink func(ev Event[EData])) {
+	var wrapped func(Event[EData]) error
+	if sink != nil {
+		wrapped = func(ev Event[EData]) error {
+			sink(ev)
+			return nil
 		}
 	}
-	if idx == len(b.subs) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update(func(s *snapshot[EData]) {
+		s.unhandledSink = wrapped
+	})
+}
+
+// Registers handler for panics recovered from event handlers (including unhandled sink).
+// Once it's set, bus keeps going after a panic: the event is marked done and the handler is called
+// with details. When it's nil (default), the panic is re-raised after the event is marked done,
+// which crashes the program. Handler's stack trace is written to stderr before that,
+// as the re-raised panic only shows bus' frames.
+// Simply set to nil to unregister. No-op if the bus is closed.
+func (b *Bus[EData]) SetPanicHandler(handler func(p HandlerPanic[EData])) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update(func(s *snapshot[EData]) {
+		s.panicHandler = handler
+	})
+}
+
+// Logs panic and passes it to panic handler, if any. Returns false if there's no panic handler.
+func (b *Bus[EData]) reportPanic(pe *PanicError, sub Subscriber[EData], ev Event[EData]) bool {
+	b.logPanic(pe, sub, ev)
+	handler := b.state.Load().panicHandler
+	if handler == nil {
 		return false
 	}
-	b.subs = append(b.subs[:idx], b.subs[idx+1:]...)
-	b.patterns = append(b.patterns[:idx], b.patterns[idx+1:]...)
-	clear(b.topicCache)
+	handler(HandlerPanic[EData]{Value: pe.Value, Stack: pe.Stack, Subscriber: sub, Event: ev})
 	return true
 }
 
-// Registers sink for events that are published but have no subscribers at the time.
-// Simply set to nil to unregister.
-func (b *Bus[EData]) SetUnhandledSink(sink func(ev Event[EData])) {
+// Registers watchdog for handlers that exceed their timeout, see WithTimeout and WithHandlerTimeout.
+// Watchdog is called from a timer goroutine, while the slow handler may still be running.
+// Simply set to nil to unregister. No-op if the bus is closed.
+func (b *Bus[EData]) SetWatchdog(watchdog func(s SlowHandler[EData])) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.unhandledSink = sink
+	b.update(func(s *snapshot[EData]) {
+		s.watchdog = watchdog
+	})
+}
+
+// Logs slow handler and passes it to watchdog, if any.
+func (b *Bus[EData]) reportSlow(s SlowHandler[EData]) {
+	b.logSlow(s)
+	if watchdog := b.state.Load().watchdog; watchdog != nil {
+		watchdog(s)
+	}
 }
@@ -16,9 +16,21 @@
 package gogoevents
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"math"
 	"math/rand"
+	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -62,7 +74,7 @@ func TestAllEventHandlersAreWaited(t *testing.T) {
 	eventsGot := atomic.Int32{}
 
 	for i := 0; i < len(subs); i++ {
-		subs[i] = eb.Subscribe(topic[:i%(len(topic)-1)+1]+"*", func(ev Event[any]) {
+		subs[i], _ = eb.Subscribe(topic[:i%(len(topic)-1)+1]+"*", func(ev Event[any]) {
 			eventsGot.Add(1)
 		})
 	}
@@ -90,7 +102,7 @@ func TestUnsubscribe(t *testing.T) {
 	subs := make([]Subscriber[any], subsCount)
 
 	for i := 0; i < len(subs); i++ {
-		subs[i] = eb.Subscribe(strconv.FormatUint(rand.Uint64(), 16), func(ev Event[any]) {
+		subs[i], _ = eb.Subscribe(strconv.FormatUint(rand.Uint64(), 16), func(ev Event[any]) {
 		})
 	}
 
@@ -109,8 +121,8 @@ func TestUnsubscribe(t *testing.T) {
 	if !res {
 		t.Fatalf("subs count changed but unsubscribe returned false")
 	}
-	if len(eb.subs) != len(eb.patterns) {
-		t.Fatalf("subs length %d != patterns length %d. Forgot to remove sub's pattern?", len(eb.subs), len(eb.patterns))
+	if len(eb.subs) != eb.state.Load().index.Len() {
+		t.Fatalf("subs length %d != index length %d. Forgot to remove sub from index?", len(eb.subs), eb.state.Load().index.Len())
 	}
 }
 
@@ -121,7 +133,7 @@ func TestUnsubscribeLast(t *testing.T) {
 	subs := make([]Subscriber[any], subsCount)
 
 	for i := 0; i < len(subs); i++ {
-		subs[i] = eb.Subscribe(strconv.FormatUint(rand.Uint64(), 16), func(ev Event[any]) {
+		subs[i], _ = eb.Subscribe(strconv.FormatUint(rand.Uint64(), 16), func(ev Event[any]) {
 		})
 	}
 
@@ -132,7 +144,7 @@ func TestUnsubscribeLast(t *testing.T) {
 		t.Fatalf("illegal subscribers count. Expected %d, got %d", subsCount, len(subs))
 	}
 
-	lastSub := eb.subs[len(eb.subs)-1]
+	lastSub := subs[len(subs)-1]
 	res := eb.Unsubscribe(lastSub)
 	if eb.TotalSubscribers() != subsCount-1 {
 		t.Fatalf("subs count hasn't changed")
@@ -140,8 +152,8 @@ func TestUnsubscribeLast(t *testing.T) {
 	if !res {
 		t.Fatalf("subs count changed but unsubscribe returned false")
 	}
-	if len(eb.subs) != len(eb.patterns) {
-		t.Fatalf("subs length %d != patterns length %d. Forgot to remove sub's pattern?", len(eb.subs), len(eb.patterns))
+	if len(eb.subs) != eb.state.Load().index.Len() {
+		t.Fatalf("subs length %d != index length %d. Forgot to remove sub from index?", len(eb.subs), eb.state.Load().index.Len())
 	}
 }
 
@@ -219,6 +231,1579 @@ func TestUnhandledSinkSkipsHandledEvent(t *testing.T) {
 	eb.Close()
 }
 
+func TestPublishContextPassesContext(t *testing.T) {
+	eb := NewUntyped()
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "val")
+
+	got := atomic.Value{}
+	eb.Subscribe("test", func(ev Event[any]) {
+		got.Store(ev.Context().Value(ctxKey{}))
+	})
+
+	wg, err := eb.PublishContext(ctx, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if v := got.Load(); v != "val" {
+		t.Fatalf("expected context value %q, got %v", "val", v)
+	}
+
+	eb.Close()
+}
+
+func TestPublishContextCancelled(t *testing.T) {
+	eb := NewUntyped()
+
+	got := atomic.Int32{}
+	eb.Subscribe("test", func(ev Event[any]) {
+		got.Add(1)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := eb.PublishContext(ctx, "test", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if gotVal := got.Load(); gotVal != 0 {
+		t.Fatalf("Expected 0 events, got %d", gotVal)
+	}
+
+	eb.Close()
+}
+
+func TestWaitContextReturnsCtxErr(t *testing.T) {
+	eb := NewUntyped()
+
+	release := make(chan struct{})
+	eb.Subscribe("test", func(ev Event[any]) {
+		<-release
+	})
+
+	wg, err := eb.Publish("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err = wg.WaitContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	close(release)
+	if err = wg.WaitContext(context.Background()); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	eb.Close()
+}
+
+func TestPublishSyncOrder(t *testing.T) {
+	eb := NewUntyped()
+
+	got := []string{}
+	for _, p := range []string{"t*", "test", "*"} {
+		p := p
+		eb.Subscribe(p, func(ev Event[any]) {
+			ev.Done()
+			got = append(got, p)
+		})
+	}
+
+	if err := eb.PublishSync("test", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"*", "t*", "test"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	eb.Close()
+}
+
+func TestPublishSyncReturnsPanics(t *testing.T) {
+	eb := NewUntyped()
+
+	got := 0
+	eb.Subscribe("a*", func(ev Event[any]) {
+		panic("boom")
+	})
+	eb.Subscribe("any", func(ev Event[any]) {
+		got++
+	})
+
+	err := eb.PublishSync("any", nil)
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *PanicError, got %v", err)
+	}
+	if pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Fatalf("bad panic error: %v, stack len %d", pe.Value, len(pe.Stack))
+	}
+	if got != 1 {
+		t.Fatalf("Expected 1 event after panic, got %d", got)
+	}
+
+	eb.Close()
+}
+
+func TestPublishSyncCancelSkipsRemaining(t *testing.T) {
+	eb := NewUntyped()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := 0
+	eb.Subscribe("a*", func(ev Event[any]) {
+		got++
+		cancel()
+	})
+	eb.Subscribe("any", func(ev Event[any]) {
+		got++
+	})
+
+	if err := eb.PublishSyncContext(ctx, "any", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Fatalf("Expected 1 event, got %d", got)
+	}
+
+	eb.Close()
+}
+
+func TestPublishSyncUnhandledSink(t *testing.T) {
+	eb := NewUntyped()
+
+	got := 0
+	eb.SetUnhandledSink(func(ev Event[any]) {
+		got++
+	})
+	eb.Subscribe("other", func(ev Event[any]) {})
+
+	if err := eb.PublishSync("any", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Fatalf("Expected 1 event, got %d", got)
+	}
+
+	eb.Close()
+}
+
+func TestMailboxKeepsOrder(t *testing.T) {
+	eb := New[int]()
+
+	got := make([]int, 0, 1000)
+	eb.Subscribe("test", func(ev Event[int]) {
+		got = append(got, *ev.Data)
+	}, WithMailbox(16, OverflowBlock))
+
+	wgs := make([]*Result, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		wg, err := eb.Publish("test", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wgs = append(wgs, wg)
+	}
+	for _, wg := range wgs {
+		wg.Wait()
+	}
+
+	if len(got) != 1000 {
+		t.Fatalf("Expected %d events, got %d", 1000, len(got))
+	}
+	for i := range got {
+		if got[i] != i {
+			t.Fatalf("event %d received at position %d", got[i], i)
+		}
+	}
+
+	eb.Close()
+}
+
+func TestMailboxDrainsOnUnsubscribe(t *testing.T) {
+	eb := New[int]()
+
+	release := make(chan struct{})
+	got := atomic.Int32{}
+	sub, _ := eb.Subscribe("test", func(ev Event[int]) {
+		<-release
+		got.Add(1)
+	}, WithMailbox(10, OverflowBlock))
+
+	wgs := make([]*Result, 0, 10)
+	for i := 0; i < 10; i++ {
+		wg, err := eb.Publish("test", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wgs = append(wgs, wg)
+	}
+
+	if !eb.Unsubscribe(sub) {
+		t.Fatal("unsubscribe failed")
+	}
+	close(release)
+	for _, wg := range wgs {
+		wg.Wait()
+	}
+	if gotVal := got.Load(); gotVal != 10 {
+		t.Fatalf("Expected %d events, got %d", 10, gotVal)
+	}
+
+	// Publish after Unsubscribe must not reach the mailbox.
+	wg, err := eb.Publish("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	eb.Close()
+}
+
+func TestMailboxSelfUnsubscribeWhenFull(t *testing.T) {
+	eb := New[int]()
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	unsubscribed := make(chan struct{})
+	var sub Subscriber[int]
+	sub, _ = eb.Subscribe("test", func(ev Event[int]) {
+		started <- struct{}{}
+		if *ev.Data == 0 {
+			<-release
+			eb.Unsubscribe(sub)
+			close(unsubscribed)
+		}
+	}, WithMailbox(1, OverflowBlock))
+
+	eb.Publish("test", 0)
+	<-started
+	eb.Publish("test", 1) // Fills the mailbox
+	blocked := make(chan error)
+	go func() {
+		_, err := eb.Publish("test", 2)
+		blocked <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	close(release)
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("unsubscribe from handler deadlocked with blocked publisher")
+	}
+	select {
+	case err := <-blocked:
+		if err != nil {
+			t.Fatalf("publish to unsubscribed mailbox must not fail, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked publisher wasn't released")
+	}
+	if err := eb.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(started); n != 1 {
+		t.Fatalf("queued event must be delivered, got %d more deliveries", n)
+	}
+}
+
+func TestPublishSyncWaitsForMailbox(t *testing.T) {
+	eb := New[int]()
+
+	got := atomic.Int32{}
+	eb.Subscribe("test", func(ev Event[int]) {
+		got.Add(1)
+	}, WithMailbox(1, OverflowBlock))
+	eb.Subscribe("test", func(ev Event[int]) {
+		panic("boom")
+	}, WithMailbox(1, OverflowBlock))
+
+	var pe *PanicError
+	if err := eb.PublishSync("test", 0); !errors.As(err, &pe) {
+		t.Fatalf("expected *PanicError, got %v", err)
+	}
+	if gotVal := got.Load(); gotVal != 1 {
+		t.Fatalf("Expected %d events, got %d", 1, gotVal)
+	}
+
+	eb.Close()
+}
+
+func TestPanicHandlerRecovers(t *testing.T) {
+	eb := NewUntyped()
+
+	got := make(chan HandlerPanic[any], 1)
+	eb.SetPanicHandler(func(p HandlerPanic[any]) {
+		got <- p
+	})
+	sub, _ := eb.Subscribe("test", func(ev Event[any]) {
+		panic("boom")
+	})
+
+	wg, err := eb.Publish("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait() // must not hang
+
+	p := <-got
+	if p.Value != "boom" || len(p.Stack) == 0 {
+		t.Fatalf("bad panic: %v, stack len %d", p.Value, len(p.Stack))
+	}
+	if p.Subscriber.ID() != sub.ID() || p.Subscriber.Pattern() != "test" || p.Event.Topic != "test" {
+		t.Fatalf("bad panic origin: subscriber %d %q, topic %q", p.Subscriber.ID(), p.Subscriber.Pattern(), p.Event.Topic)
+	}
+
+	eb.Close()
+}
+
+// Runs tasks in goroutines, passing their panics to `recovered`.
+type recoveringDispatcher struct {
+	recovered chan any
+}
+
+func (d recoveringDispatcher) Dispatch(t Task) error {
+	go func() {
+		defer func() { d.recovered <- recover() }()
+		t.Run()
+	}()
+	return nil
+}
+
+func TestPanicKeepsHandlerStack(t *testing.T) {
+	var out bytes.Buffer
+	panicOutput = &out
+	defer func() { panicOutput = os.Stderr }()
+
+	d := recoveringDispatcher{recovered: make(chan any, 1)}
+	eb := New[int](WithDispatcher(d))
+	eb.Subscribe("test", func(ev Event[int]) {
+		panic("boom")
+	})
+	if _, err := eb.Publish("test", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := <-d.recovered; r != "boom" {
+		t.Fatalf("expected re-raised panic value, got %v", r)
+	}
+	if !strings.Contains(out.String(), "TestPanicKeepsHandlerStack") {
+		t.Fatalf("expected handler's stack, got %q", out.String())
+	}
+}
+
+func TestSubscribeEErrorsAreJoined(t *testing.T) {
+	eb := NewUntyped()
+
+	errA, errB := errors.New("a failed"), errors.New("b failed")
+	subA, _ := eb.SubscribeE("test", func(ev Event[any]) error {
+		return errA
+	})
+	subB, _ := eb.SubscribeE("t*", func(ev Event[any]) error {
+		return errB
+	})
+	eb.SubscribeE("test", func(ev Event[any]) error {
+		return nil
+	})
+
+	res, err := eb.Publish("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = res.Wait()
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Fatalf("expected both handler errors, got %v", err)
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("expected 2 joined errors, got %v", err)
+	}
+	for _, e := range joined.Unwrap() {
+		var he *HandlerError
+		if !errors.As(e, &he) {
+			t.Fatalf("expected *HandlerError, got %T", e)
+		}
+		if he.Topic != "test" {
+			t.Fatalf("expected topic %q, got %q", "test", he.Topic)
+		}
+		switch he.Err {
+		case errA:
+			if he.SubscriberID != subA.ID() || he.Pattern != subA.Pattern() {
+				t.Fatalf("error %v tagged with wrong subscriber", he)
+			}
+		case errB:
+			if he.SubscriberID != subB.ID() || he.Pattern != subB.Pattern() {
+				t.Fatalf("error %v tagged with wrong subscriber", he)
+			}
+		}
+	}
+
+	if err = eb.PublishSync("test", nil); !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Fatalf("expected both handler errors from PublishSync, got %v", err)
+	}
+
+	eb.Close()
+}
+
+func TestRetry(t *testing.T) {
+	eb := NewUntyped()
+
+	errTransient := errors.New("transient")
+	attempts := []int{}
+	eb.SubscribeE("test", func(ev Event[any]) error {
+		attempts = append(attempts, ev.Attempt())
+		if ev.Attempt() < 3 {
+			return errTransient
+		}
+		return nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, Jitter: 0.5}))
+
+	others := atomic.Int32{}
+	eb.Subscribe("t*", func(ev Event[any]) {
+		others.Add(1)
+	})
+
+	res, err := eb.Publish("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = res.Wait(); err != nil {
+		t.Fatalf("expected no error after successful retry, got %v", err)
+	}
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[2] != 3 {
+		t.Fatalf("expected attempts [1 2 3], got %v", attempts)
+	}
+	if gotVal := others.Load(); gotVal != 1 {
+		t.Fatalf("only failing subscriber must be retried. Other got %d events", gotVal)
+	}
+
+	eb.Close()
+}
+
+func TestRetryStops(t *testing.T) {
+	eb := NewUntyped()
+
+	errPermanent := errors.New("permanent")
+	attempts := atomic.Int32{}
+	eb.SubscribeE("test", func(ev Event[any]) error {
+		if attempts.Add(1) == 1 {
+			return errors.New("transient")
+		}
+		return errPermanent
+	}, WithRetry(RetryPolicy{
+		MaxAttempts: 5,
+		Retryable:   func(err error) bool { return err != errPermanent },
+	}))
+	eb.SubscribeE("other", func(ev Event[any]) error {
+		attempts.Add(1)
+		return errPermanent
+	}, WithRetry(RetryPolicy{MaxAttempts: 3}))
+
+	if err := eb.PublishSync("test", nil); !errors.Is(err, errPermanent) {
+		t.Fatalf("expected %v, got %v", errPermanent, err)
+	}
+	if gotVal := attempts.Load(); gotVal != 2 {
+		t.Fatalf("expected 2 attempts, got %d", gotVal)
+	}
+
+	attempts.Store(0)
+	if err := eb.PublishSync("other", nil); !errors.Is(err, errPermanent) {
+		t.Fatalf("expected %v, got %v", errPermanent, err)
+	}
+	if gotVal := attempts.Load(); gotVal != 3 {
+		t.Fatalf("expected 3 attempts, got %d", gotVal)
+	}
+
+	eb.Close()
+}
+
+func TestRetryBackoffDoesNotOverflow(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10000, InitialBackoff: time.Second}
+	prev := time.Duration(0)
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		delay, ok := p.next(attempt, errors.New("failed"))
+		if !ok || delay < prev {
+			t.Fatalf("attempt %d: got delay %v after %v", attempt, delay, prev)
+		}
+		prev = delay
+	}
+	if prev != math.MaxInt64 {
+		t.Fatalf("expected delay to saturate, got %v", prev)
+	}
+
+	p.Jitter = 0.5
+	if delay, _ := p.next(5000, nil); delay < math.MaxInt64/2 {
+		t.Fatalf("jittered delay must stay positive and large, got %v", delay)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	eb := New[int]()
+
+	dls := make(chan DeadLetter[int], 10)
+	eb.SetDeadLetterSink(func(dl DeadLetter[int]) {
+		dls <- dl
+	})
+	eb.SetPanicHandler(func(p HandlerPanic[int]) {})
+
+	errFailed := errors.New("failed")
+	eb.SubscribeE("failing", func(ev Event[int]) error {
+		return errFailed
+	}, WithRetry(RetryPolicy{MaxAttempts: 2}))
+	eb.Subscribe("panicking", func(ev Event[int]) {
+		panic("boom")
+	})
+	release := make(chan struct{})
+	eb.Subscribe("slow", func(ev Event[int]) {
+		<-release
+	}, WithMailbox(0, OverflowDropNewest))
+
+	check := func(topic string, reason DeadLetterReason, attempts int) {
+		t.Helper()
+		dl := <-dls
+		if dl.Event.Topic != topic || dl.Subscriber.Pattern() != topic || dl.Reason != reason || dl.Attempts != attempts {
+			t.Fatalf("expected %s dead letter on %q after %d attempts, got %s on %q (%q) after %d",
+				reason, topic, attempts, dl.Reason, dl.Event.Topic, dl.Subscriber.Pattern(), dl.Attempts)
+		}
+	}
+
+	eb.PublishSync("failing", 1)
+	check("failing", ReasonFailed, 2)
+
+	eb.PublishSync("panicking", 2)
+	check("panicking", ReasonPanicked, 1)
+
+	res, _ := eb.Publish("slow", 3) // occupies the mailbox worker
+	for {
+		// Worker may not be ready to take the first event yet, so retry until one is dropped.
+		eb.Publish("slow", 4)
+		if len(dls) > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	check("slow", ReasonDropped, 0)
+	close(release)
+	res.Wait()
+
+	eb.Close()
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	eb := NewUntyped(WithHandlerTimeout(time.Hour))
+
+	slow := make(chan SlowHandler[any], 1)
+	eb.SetWatchdog(func(s SlowHandler[any]) {
+		slow <- s
+	})
+	dls := make(chan DeadLetter[any], 1)
+	eb.SetDeadLetterSink(func(dl DeadLetter[any]) {
+		dls <- dl
+	})
+
+	release := make(chan struct{})
+	cause := make(chan error, 1)
+	eb.Subscribe("stuck", func(ev Event[any]) {
+		<-ev.Context().Done()
+		cause <- context.Cause(ev.Context())
+		<-release
+	}, WithTimeout(50*time.Millisecond))
+
+	res, err := eb.Publish("stuck", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = res.Wait(); !errors.Is(err, ErrHandlerTimeout) {
+		t.Fatalf("expected %v, got %v", ErrHandlerTimeout, err)
+	}
+	if err = <-cause; err != ErrHandlerTimeout {
+		t.Fatalf("expected context cause %v, got %v", ErrHandlerTimeout, err)
+	}
+
+	s := <-slow
+	if s.Subscriber.Pattern() != "stuck" || s.Event.Topic != "stuck" || s.Elapsed < 50*time.Millisecond {
+		t.Fatalf("bad slow handler report: %q on %q after %v", s.Subscriber.Pattern(), s.Event.Topic, s.Elapsed)
+	}
+	if dl := <-dls; dl.Reason != ReasonTimedOut {
+		t.Fatalf("expected %s dead letter, got %s", ReasonTimedOut, dl.Reason)
+	}
+	close(release)
+
+	// Bus-wide timeout must not affect fast handlers.
+	eb.Subscribe("fast", func(ev Event[any]) {})
+	if err = eb.PublishSync("fast", nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	eb.Close()
+}
+
+func TestShutdownWaitsForDeliveries(t *testing.T) {
+	eb := New[int]()
+
+	got := atomic.Int32{}
+	eb.Subscribe("test", func(ev Event[int]) {
+		time.Sleep(100 * time.Millisecond)
+		got.Add(1)
+	})
+	eb.Subscribe("test", func(ev Event[int]) {
+		time.Sleep(10 * time.Millisecond)
+		got.Add(1)
+	}, WithMailbox(10, OverflowBlock))
+
+	for i := 0; i < 5; i++ {
+		if _, err := eb.Publish("test", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := eb.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if gotVal := got.Load(); gotVal != 10 {
+		t.Fatalf("Expected %d events, got %d", 10, gotVal)
+	}
+
+	if _, err := eb.Publish("test", 0); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected %v from Publish, got %v", ErrClosed, err)
+	}
+	if err := eb.PublishSync("test", 0); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected %v from PublishSync, got %v", ErrClosed, err)
+	}
+	if _, err := eb.Subscribe("test", func(ev Event[int]) {}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected %v from Subscribe, got %v", ErrClosed, err)
+	}
+	if eb.TotalSubscribers() != 0 {
+		t.Fatalf("expected no subscribers, got %d", eb.TotalSubscribers())
+	}
+	if err := eb.Shutdown(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected %v from second Shutdown, got %v", ErrClosed, err)
+	}
+	if err := eb.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected %v from Close, got %v", ErrClosed, err)
+	}
+}
+
+func TestShutdownReportsAbandoned(t *testing.T) {
+	eb := New[int]()
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 1)
+	eb.Subscribe("test", func(ev Event[int]) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	}, WithMailbox(10, OverflowBlock))
+
+	for i := 0; i < 3; i++ {
+		if _, err := eb.Publish("test", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := eb.Shutdown(ctx)
+	var se *ShutdownError
+	if !errors.As(err, &se) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected *ShutdownError, got %v", err)
+	}
+	if se.Running != 1 || se.Queued != 2 {
+		t.Fatalf("expected 1 running and 2 queued deliveries abandoned, got %d and %d", se.Running, se.Queued)
+	}
+}
+
+func TestHierarchy(t *testing.T) {
+	eb := NewUntyped(WithHierarchy("."))
+
+	got := map[string]*atomic.Int32{}
+	for _, p := range []string{"orders.*", "orders.+.created", "orders.#", "orders.>"} {
+		cnt := &atomic.Int32{}
+		got[p] = cnt
+		if _, err := eb.Subscribe(p, func(ev Event[any]) {
+			cnt.Add(1)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, topic := range []string{"orders", "orders.created", "orders.eu.created"} {
+		if err := eb.PublishSync(topic, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]int32{"orders.*": 1, "orders.+.created": 1, "orders.#": 3, "orders.>": 3}
+	for p, cnt := range got {
+		if cnt.Load() != want[p] {
+			t.Fatalf("%q: expected %d events, got %d", p, want[p], cnt.Load())
+		}
+	}
+
+	if _, err := eb.Subscribe("orders.#.created", func(ev Event[any]) {}); !errors.Is(err, ErrInvalidPattern) {
+		t.Fatalf("expected %v, got %v", ErrInvalidPattern, err)
+	}
+	if _, err := eb.Publish("orders.+", nil); !errors.Is(err, ErrIllegalWildcard) {
+		t.Fatalf("expected %v, got %v", ErrIllegalWildcard, err)
+	}
+
+	eb.Close()
+}
+
+func TestHierarchySeparatorMustNotBeWildcard(t *testing.T) {
+	for _, sep := range []string{"+", "#", ">", "*", "/*"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for separator %q", sep)
+				}
+			}()
+			WithHierarchy(sep)
+		}()
+	}
+}
+
+// Meant to be run with race detector.
+func TestConcurrentPublishSubscribe(t *testing.T) {
+	eb := New[int](WithTopicCacheSize(4))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				sub, err := eb.Subscribe("t."+strconv.Itoa(n%8)+"*", func(ev Event[int]) { ev.Done() })
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				eb.Unsubscribe(sub)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var got atomic.Int32
+			sub, _ := eb.Subscribe("t."+strconv.Itoa(i), func(ev Event[int]) {
+				got.Add(1)
+				ev.Done()
+			})
+			defer eb.Unsubscribe(sub)
+
+			for n := 0; n < 1000; n++ {
+				// Other publishers never publish to this subscriber's topic.
+				topic := sub.Pattern()
+				if n%2 == 1 {
+					topic = "t.x" + strconv.Itoa(n%16)
+				}
+				if err := eb.PublishSync(topic, n); err != nil {
+					t.Error(err)
+					return
+				}
+				// Own subscription must never be missed, despite concurrent changes.
+				if topic == sub.Pattern() && got.Swap(0) != 1 {
+					t.Errorf("%s: event not delivered to own subscriber", topic)
+					return
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
+
+func TestConcurrentShutdown(t *testing.T) {
+	eb := New[int]()
+	var delivered atomic.Int32
+	eb.Subscribe("*", func(ev Event[int]) {
+		delivered.Add(1)
+		ev.Done()
+	})
+
+	var wg sync.WaitGroup
+	var published atomic.Int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := eb.Publish("t", 0); err != nil {
+					if !errors.Is(err, ErrClosed) {
+						t.Error(err)
+					}
+					return
+				}
+				published.Add(1)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := eb.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if delivered.Load() != published.Load() {
+		t.Errorf("delivered %d of %d published events", delivered.Load(), published.Load())
+	}
+}
+
+func TestTopicCache(t *testing.T) {
+	eb := New[int](WithTopicCacheSize(2))
+	eb.Subscribe("a.*", func(ev Event[int]) { ev.Done() })
+
+	for _, topic := range []string{"a.1", "a.2", "a.1", "a.3"} {
+		if err := eb.PublishSync(topic, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := eb.CacheStats()
+	expected := CacheStats{Hits: 1, Misses: 3, Evictions: 1, Len: 2, Capacity: 2}
+	if stats != expected {
+		t.Fatalf("got %+v, expected %+v", stats, expected)
+	}
+
+	// Only affected topics are invalidated.
+	eb.Subscribe("a.3", func(ev Event[int]) { ev.Done() })
+	if stats := eb.CacheStats(); stats.Len != 1 {
+		t.Fatalf("got %d cached topics, expected 1", stats.Len)
+	}
+
+	var called atomic.Int32
+	eb.Subscribe("a.*", func(ev Event[int]) { called.Add(1); ev.Done() })
+	eb.PublishSync("a.1", 0)
+	if called.Load() != 1 {
+		t.Error("new subscriber must receive event for previously cached topic")
+	}
+}
+
+func TestGlobSyntax(t *testing.T) {
+	eb := New[int]()
+	got := map[string]*atomic.Int32{}
+	for _, p := range []string{`orders.{created,updated}`, `orders.?u`, `orders.[a-c]*`, `orders.\*`} {
+		cnt := &atomic.Int32{}
+		got[p] = cnt
+		if _, err := eb.Subscribe(p, func(ev Event[int]) { cnt.Add(1) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, topic := range []string{"orders.created", "orders.updated", "orders.eu", "orders.*"} {
+		if err := eb.PublishSync(topic, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]int32{`orders.{created,updated}`: 2, `orders.?u`: 1, `orders.[a-c]*`: 1, `orders.\*`: 1}
+	for p, n := range want {
+		if got[p].Load() != n {
+			t.Errorf("%s: got %d events, expected %d", p, got[p].Load(), n)
+		}
+	}
+
+	if _, err := eb.Subscribe("orders.[", func(ev Event[int]) {}); !errors.Is(err, ErrInvalidPattern) {
+		t.Fatalf("expected %v, got %v", ErrInvalidPattern, err)
+	}
+}
+
+func TestSubscribeRegexp(t *testing.T) {
+	eb := New[int]()
+	var glob, re, unhandled atomic.Int32
+	eb.SetUnhandledSink(func(ev Event[int]) { unhandled.Add(1) })
+
+	// Same pattern in both syntaxes must not be mixed up.
+	eb.Subscribe("orders.*", func(ev Event[int]) { glob.Add(1) })
+	if err := eb.PublishSync("orders.42", 0); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := eb.SubscribeRegexp(regexp.MustCompile(`orders.*`), func(ev Event[int]) { re.Add(1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchored, _ := eb.SubscribeRegexp(regexp.MustCompile(`^orders\.\d+$`), func(ev Event[int]) { re.Add(1) })
+
+	for _, topic := range []string{"orders.42", "eu.orders", "orders.x", "other"} {
+		if err := eb.PublishSync(topic, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if glob.Load() != 3 || re.Load() != 4 || unhandled.Load() != 1 {
+		t.Fatalf("got %d glob, %d regexp and %d unhandled events, expected 3, 4 and 1", glob.Load(), re.Load(), unhandled.Load())
+	}
+	if sub.Pattern() != "orders.*" || eb.TotalSubscribers() != 3 {
+		t.Fatalf("unexpected pattern %q or subscriber count %d", sub.Pattern(), eb.TotalSubscribers())
+	}
+
+	eb.Unsubscribe(sub)
+	eb.Unsubscribe(anchored)
+	re.Store(0)
+	eb.PublishSync("orders.42", 0)
+	if re.Load() != 0 || eb.TotalSubscribers() != 1 {
+		t.Fatalf("regexp subscribers must be removed, got %d events and %d subscribers", re.Load(), eb.TotalSubscribers())
+	}
+
+	if _, err := eb.SubscribeRegexp(nil, func(ev Event[int]) {}); !errors.Is(err, ErrInvalidPattern) {
+		t.Fatalf("expected %v, got %v", ErrInvalidPattern, err)
+	}
+
+	for expr, prefix := range map[string]string{`^orders\.\d+$`: "orders.", `orders\.\d+`: "", `^a|^b`: "", `(?m)^orders`: ""} {
+		if got, _ := newRegexpMatcher(regexp.MustCompile(expr)).LiteralPrefix(); got != prefix {
+			t.Errorf("%s: got prefix %q, expected %q", expr, got, prefix)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	eb := New[int]()
+	var even, big, unhandled atomic.Int32
+	eb.SetUnhandledSink(func(ev Event[int]) { unhandled.Add(1) })
+
+	isEven := func(ev Event[int]) bool { return *ev.Data%2 == 0 }
+	isBig := func(ev Event[int]) bool { return *ev.Data > 10 }
+	eb.SubscribeWith("n", Subscription[int]{Filter: isEven}, func(ev Event[int]) error {
+		even.Add(1)
+		return nil
+	})
+	eb.SubscribeRegexpWith(regexp.MustCompile("^n$"), Subscription[int]{Filter: isBig}, func(ev Event[int]) error {
+		big.Add(1)
+		return nil
+	})
+
+	for _, n := range []int{1, 2, 12, 13} {
+		if err := eb.PublishSync("n", n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if even.Load() != 2 || big.Load() != 2 || unhandled.Load() != 1 {
+		t.Fatalf("got %d even, %d big and %d unhandled events, expected 2, 2 and 1", even.Load(), big.Load(), unhandled.Load())
+	}
+
+	res, err := eb.Publish("n", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Wait(); err != nil || unhandled.Load() != 2 {
+		t.Fatalf("rejected event must be unhandled, got %v and %d unhandled events", err, unhandled.Load())
+	}
+}
+
+func TestSubscribeN(t *testing.T) {
+	eb := New[int]()
+	var got, unhandled atomic.Int32
+	eb.SetUnhandledSink(func(ev Event[int]) { unhandled.Add(1) })
+	eb.Subscribe("other", func(ev Event[int]) {})
+	if _, err := eb.SubscribeN("t", 5, func(ev Event[int]) { got.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				res, err := eb.Publish("t", j)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				res.Wait()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got.Load() != 5 || unhandled.Load() != 75 {
+		t.Fatalf("got %d deliveries and %d unhandled events, expected 5 and 75", got.Load(), unhandled.Load())
+	}
+	if eb.TotalSubscribers() != 1 {
+		t.Fatalf("spent subscriber must be removed, got %d subscribers", eb.TotalSubscribers())
+	}
+
+	if _, err := eb.SubscribeN("t", 0, func(ev Event[int]) {}); !errors.Is(err, ErrInvalidLimit) {
+		t.Fatalf("expected %v, got %v", ErrInvalidLimit, err)
+	}
+}
+
+func TestSubscribeOnce(t *testing.T) {
+	eb := New[int]()
+	var got atomic.Int32
+	positive := Subscription[int]{Filter: func(ev Event[int]) bool { return *ev.Data > 0 }}
+	eb.SubscribeWith("t", positive, func(ev Event[int]) error {
+		got.Add(1)
+		return nil
+	}, WithMailbox(1, OverflowBlock), WithLimit(1))
+
+	for i := 0; i < 3; i++ {
+		if err := eb.PublishSync("t", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rejected event isn't counted, and the last delivery isn't lost despite mailbox being stopped.
+	if got.Load() != 1 || eb.TotalSubscribers() != 0 {
+		t.Fatalf("got %d deliveries and %d subscribers, expected 1 and 0", got.Load(), eb.TotalSubscribers())
+	}
+}
+
+func TestExpect(t *testing.T) {
+	eb := New[int]()
+	e, err := eb.Expect("orders.*", func(ev Event[int]) bool { return *ev.Data == 2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		eb.Publish("orders.created", i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ev, err := e.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *ev.Data != 2 || ev.Topic != "orders.created" {
+		t.Fatalf("got %d at %s, expected 2 at orders.created", *ev.Data, ev.Topic)
+	}
+	if eb.TotalSubscribers() != 0 {
+		t.Fatal("temporary subscriber must be removed")
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	eb := New[int]()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := eb.WaitFor(ctx, "t", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if eb.TotalSubscribers() != 0 {
+		t.Fatal("temporary subscriber must be removed on timeout")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		eb.Close()
+	}()
+	if _, err := eb.WaitFor(context.Background(), "t", nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	eb := New[int](WithRequestTimeout(50 * time.Millisecond))
+	eb.Subscribe("double", func(ev Event[int]) {
+		if err := ev.Reply(*ev.Data * 2); err != nil {
+			t.Error(err)
+		}
+	})
+
+	reply, err := eb.Request(context.Background(), "double", 21)
+	if err != nil || reply != 42 {
+		t.Fatalf("got %d, %v, expected 42, nil", reply, err)
+	}
+
+	var unhandled atomic.Int32
+	eb.SetUnhandledSink(func(ev Event[int]) { unhandled.Add(1) })
+	if _, err := eb.Request(context.Background(), "nobody", 0); !errors.Is(err, ErrNoResponders) {
+		t.Fatalf("expected %v, got %v", ErrNoResponders, err)
+	}
+
+	eb.Subscribe("silent", func(ev Event[int]) {})
+	if _, err := eb.Request(context.Background(), "silent", 0); !errors.Is(err, ErrNoReply) {
+		t.Fatalf("expected %v, got %v", ErrNoReply, err)
+	}
+
+	eb.Subscribe("slow", func(ev Event[int]) {
+		<-ev.Context().Done()
+	})
+	if _, err := eb.Request(context.Background(), "slow", 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	eb.Subscribe("notify", func(ev Event[int]) {
+		if err := ev.Reply(0); !errors.Is(err, ErrNotRequest) {
+			t.Errorf("expected %v, got %v", ErrNotRequest, err)
+		}
+	})
+	eb.PublishSync("notify", 0)
+	if unhandled.Load() != 1 {
+		t.Fatalf("request without responders must be unhandled, got %d unhandled events", unhandled.Load())
+	}
+}
+
+func TestRequestMany(t *testing.T) {
+	eb := New[int]()
+	for i := 1; i <= 3; i++ {
+		i := i
+		eb.Subscribe("count", func(ev Event[int]) {
+			ev.Reply(i)
+		})
+	}
+
+	replies, err := eb.RequestMany(context.Background(), "count", 0, 0)
+	if err != nil || len(replies) != 3 {
+		t.Fatalf("got %v, %v, expected 3 replies", replies, err)
+	}
+
+	replies, err = eb.RequestMany(context.Background(), "count", 0, 2)
+	if err != nil || len(replies) != 2 {
+		t.Fatalf("got %v, %v, expected 2 replies", replies, err)
+	}
+
+	eb.Subscribe("count", func(ev Event[int]) {
+		<-ev.Context().Done()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	replies, err = eb.RequestMany(ctx, "count", 0, 0)
+	if !errors.Is(err, context.DeadlineExceeded) || len(replies) != 3 {
+		t.Fatalf("got %v, %v, expected 3 replies gathered until deadline", replies, err)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	eb := New[int](WithSource("billing"))
+	var first, second Event[int]
+	eb.Subscribe("first", func(ev Event[int]) {
+		first = ev
+		eb.PublishSync("second", 0, WithEventSource("worker"), CausedBy(ev))
+	})
+	eb.Subscribe("second", func(ev Event[int]) {
+		second = ev
+	})
+
+	before := time.Now()
+	headers := Headers{"tenant": "acme"}
+	if err := eb.PublishSync("first", 0, WithHeaders(headers), WithHeader("user", "42")); err != nil {
+		t.Fatal(err)
+	}
+	headers["tenant"] = "changed"
+
+	if first.ID == "" || first.ID == second.ID {
+		t.Fatalf("events must have unique ids, got %q and %q", first.ID, second.ID)
+	}
+	if first.Time.Before(before) || first.Source != "billing" || second.Source != "worker" {
+		t.Fatalf("unexpected time %v or sources %q, %q", first.Time, first.Source, second.Source)
+	}
+	if first.Headers.Get("tenant") != "acme" || first.Headers.Get("user") != "42" || second.Headers.Get("tenant") != "" {
+		t.Fatalf("unexpected headers %v and %v", first.Headers, second.Headers)
+	}
+	if first.CorrelationID != first.ID || first.CausationID != "" {
+		t.Fatalf("root event must correlate to itself, got %q, caused by %q", first.CorrelationID, first.CausationID)
+	}
+	if second.CorrelationID != first.ID || second.CausationID != first.ID {
+		t.Fatalf("follow-up event must be caused by %q, got correlation %q and causation %q", first.ID, second.CorrelationID, second.CausationID)
+	}
+
+	eb.PublishSync("second", 0, WithEventID("external-1"), WithCorrelationID("request-7"))
+	if second.ID != "external-1" || second.CorrelationID != "request-7" {
+		t.Fatalf("got id %q and correlation %q", second.ID, second.CorrelationID)
+	}
+
+	eb.UsePublishMiddleware(func(next PublishFunc[int]) PublishFunc[int] {
+		return func(ev *Event[int]) error {
+			ev.Headers.Set("stamp", "1")
+			return next(ev)
+		}
+	})
+	if err := eb.PublishSync("second", 0); err != nil || second.Headers.Get("stamp") != "1" {
+		t.Fatalf("middleware must be able to set headers, got %v and %v", err, second.Headers)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ev, err := eb.WaitFor(ctx, "never", nil)
+	if err == nil || ev.ID != "" || ev.Headers.Get("tenant") != "" || ev.Context() == nil {
+		t.Fatalf("expected zero event on error, got %+v, %v", ev, err)
+	}
+}
+
+func TestPublishMiddleware(t *testing.T) {
+	errForbidden := errors.New("forbidden")
+	eb := New[int]()
+	eb.UsePublishMiddleware(func(next PublishFunc[int]) PublishFunc[int] {
+		return func(ev *Event[int]) error {
+			switch ev.Topic {
+			case "secret":
+				return errForbidden
+			case "noise":
+				return nil
+			case "old":
+				ev.Topic = "new"
+			}
+			ev.Headers = ev.Headers.Clone()
+			ev.Headers["seen"] = "yes"
+			return next(ev)
+		}
+	})
+
+	var got []string
+	eb.Subscribe("*", func(ev Event[int]) {
+		got = append(got, ev.Topic+":"+ev.Headers.Get("seen"))
+	})
+	guard := func(next PublishFunc[int]) PublishFunc[int] {
+		return func(ev *Event[int]) error {
+			if *ev.Data < 0 {
+				return errForbidden
+			}
+			return next(ev)
+		}
+	}
+	eb.SubscribeWith("guarded", Subscription[int]{PublishMiddleware: []PublishMiddleware[int]{guard}}, func(ev Event[int]) error {
+		t.Fatal("rejected delivery must not be handled")
+		return nil
+	})
+
+	if err := eb.PublishSync("secret", 0); !errors.Is(err, errForbidden) {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+	if err := eb.PublishSync("noise", 0); err != nil {
+		t.Fatal(err)
+	}
+	res, err := eb.Publish("noise", 0)
+	if err != nil || res.Wait() != nil {
+		t.Fatalf("dropped event must have empty result, got %v", err)
+	}
+	if err := eb.PublishSync("old", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"new:yes"}) {
+		t.Fatalf("unexpected deliveries %v", got)
+	}
+
+	got = nil
+	if err := eb.PublishSync("guarded", -1); !errors.Is(err, errForbidden) {
+		t.Fatalf("expected rejected delivery, got %v", err)
+	}
+	if !slices.Equal(got, []string{"guarded:yes"}) {
+		t.Fatalf("other subscribers must get the event, got %v", got)
+	}
+	if _, err := eb.Publish("guarded", -1); !errors.Is(err, errForbidden) {
+		t.Fatalf("expected rejected delivery, got %v", err)
+	}
+}
+
+func TestHandlerMiddleware(t *testing.T) {
+	var calls []string
+	trace := func(name string) HandlerMiddleware[int] {
+		return func(next HandlerFunc[int]) HandlerFunc[int] {
+			return func(ev Event[int]) error {
+				calls = append(calls, name)
+				return next(ev)
+			}
+		}
+	}
+
+	eb := New[int]()
+	eb.UseHandlerMiddleware(trace("bus1"), trace("bus2"))
+	eb.SubscribeWith("a", Subscription[int]{HandlerMiddleware: []HandlerMiddleware[int]{trace("sub")}}, func(ev Event[int]) error {
+		calls = append(calls, "handler")
+		if ev.Attempt() == 1 {
+			return errors.New("retry")
+		}
+		return nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 2}))
+
+	if err := eb.PublishSync("a", 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bus1", "bus2", "sub", "handler", "bus1", "bus2", "sub", "handler"}
+	if !slices.Equal(calls, want) {
+		t.Fatalf("expected %v, got %v", want, calls)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	c := &Collector{}
+	eb := New[int](WithMetrics(c))
+	eb.SetPanicHandler(func(p HandlerPanic[int]) {})
+	eb.SubscribeE("ok", func(ev Event[int]) error {
+		if *ev.Data < 0 {
+			return errors.New("negative")
+		}
+		return nil
+	})
+	eb.Subscribe("panic", func(ev Event[int]) {
+		panic("boom")
+	})
+	reject := func(next PublishFunc[int]) PublishFunc[int] {
+		return func(ev *Event[int]) error {
+			return errors.New("dropped")
+		}
+	}
+	eb.SubscribeWith("drop", Subscription[int]{PublishMiddleware: []PublishMiddleware[int]{reject}}, func(ev Event[int]) error {
+		return nil
+	})
+
+	eb.PublishSync("ok", 1)
+	eb.PublishSync("ok", -1)
+	eb.PublishSync("panic", 0)
+	eb.PublishSync("drop", 0)
+	eb.PublishSync("nobody", 0)
+
+	s := c.Snapshot()
+	if s.Published["ok"] != 2 || s.Published["nobody"] != 1 || s.Unhandled != 1 {
+		t.Fatalf("unexpected publishes %v, %d unhandled", s.Published, s.Unhandled)
+	}
+	if s.Delivered != 3 || s.Failed != 2 || s.Panics != 1 || s.Dropped != 1 || s.InFlight != 0 {
+		t.Fatalf("unexpected deliveries %+v", s)
+	}
+	if s.CacheHits != 1 || s.CacheMisses != 4 || s.CacheHitRate() != 0.2 {
+		t.Fatalf("unexpected cache hits %d and misses %d", s.CacheHits, s.CacheMisses)
+	}
+	if h := s.Latency["ok"]; h.Count != 2 || len(h.Counts) != len(h.Bounds)+1 {
+		t.Fatalf("unexpected latency histogram %+v", h)
+	}
+
+	var exported MetricsSnapshot
+	if err := json.Unmarshal([]byte(c.Expvar().String()), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Published["ok"] != 2 {
+		t.Fatalf("unexpected exported publishes %v", exported.Published)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	eb := New[int](WithLogger(logger), WithLogSampling(2))
+	eb.SetPanicHandler(func(p HandlerPanic[int]) {})
+
+	sub, _ := eb.Subscribe("panic", func(ev Event[int]) {
+		panic("boom")
+	})
+	eb.PublishSync("panic", 0, WithEventID("ev-1"))
+	eb.Unsubscribe(sub)
+	for i := 0; i < 3; i++ {
+		eb.PublishSync("nobody", 0)
+	}
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+
+	var msgs []string
+	for _, r := range records {
+		msgs = append(msgs, r["msg"].(string))
+	}
+	want := []string{"subscribed", "handler panicked", "unsubscribed", "unhandled event", "unhandled event"}
+	if !slices.Equal(msgs, want) {
+		t.Fatalf("expected %v, got %v", want, msgs)
+	}
+
+	p := records[1]
+	if p["level"] != "ERROR" || p["topic"] != "panic" || p["event_id"] != "ev-1" || p["pattern"] != "panic" ||
+		p["subscriber_id"] != float64(sub.ID()) || p["panic"] != "boom" {
+		t.Fatalf("unexpected panic record %v", p)
+	}
+	if records[3]["count"] != float64(1) || records[4]["count"] != float64(3) {
+		t.Fatalf("unexpected sampling counts %v and %v", records[3]["count"], records[4]["count"])
+	}
+}
+
+// Log handler calling `callback` for every record, like a bridge logging back to the bus.
+type callbackHandler struct {
+	slog.Handler
+	callback func()
+}
+
+func (h callbackHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.callback()
+	return h.Handler.Handle(ctx, r)
+}
+
+func TestLoggerMayCallBus(t *testing.T) {
+	var eb *Bus[int]
+	var records atomic.Int32
+	handler := callbackHandler{
+		Handler:  slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		callback: func() { eb.SetUnhandledSink(nil); records.Add(1) },
+	}
+	eb = New[int](WithLogger(slog.New(handler)))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sub, _ := eb.Subscribe("test", func(ev Event[int]) {})
+		eb.Unsubscribe(sub)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("log handler calling the bus deadlocked")
+	}
+	if records.Load() != 2 {
+		t.Fatalf("expected 2 records, got %d", records.Load())
+	}
+}
+
+type traceKey struct{}
+
+// Tracer recording spans as "kind topic-or-pattern trace", with trace id propagated in "trace" header.
+type testTracer struct {
+	mu    sync.Mutex
+	spans []string
+	ids   int
+}
+
+func (t *testTracer) start(ctx context.Context, kind, name string, headers Headers) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	trace, ok := ctx.Value(traceKey{}).(string)
+	if !ok {
+		trace = headers.Get("trace")
+	}
+	if trace == "" {
+		t.ids++
+		trace = strconv.Itoa(t.ids)
+	}
+	return context.WithValue(ctx, traceKey{}, trace), testSpan(func(err error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.spans = append(t.spans, fmt.Sprintf("%s %s %s %v", kind, name, trace, err))
+	})
+}
+
+func (t *testTracer) StartPublish(ctx context.Context, info TraceInfo) (context.Context, Span) {
+	ctx, span := t.start(ctx, "publish", info.Topic, info.Headers)
+	info.Headers.Set("trace", ctx.Value(traceKey{}).(string))
+	return ctx, span
+}
+
+func (t *testTracer) StartMatch(ctx context.Context, info TraceInfo) Span {
+	_, span := t.start(ctx, "match", info.Topic, info.Headers)
+	return span
+}
+
+func (t *testTracer) StartHandle(ctx context.Context, info TraceInfo) (context.Context, Span) {
+	return t.start(ctx, "handle", info.Pattern, info.Headers)
+}
+
+type testSpan func(err error)
+
+func (s testSpan) End(err error) {
+	s(err)
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &testTracer{}
+	eb := New[int](WithTracer(tracer))
+	errFailed := errors.New("failed")
+	eb.SubscribeE("order.*", func(ev Event[int]) error {
+		if ev.Context().Value(traceKey{}) == nil {
+			t.Error("handler context must carry the trace")
+		}
+		eb.PublishSync("invoice", 0, CausedBy(ev))
+		return errFailed
+	})
+	eb.Subscribe("invoice", func(ev Event[int]) {})
+
+	eb.PublishSync("order.created", 0)
+	want := []string{
+		"match order.created 1 <nil>",
+		"match invoice 1 <nil>",
+		"handle invoice 1 <nil>",
+		"publish invoice 1 <nil>",
+		"handle order.* 1 failed",
+		"publish order.created 1 subscriber", // Error message is checked by prefix
+	}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("expected %v, got %v", want, tracer.spans)
+	}
+	for i := range want {
+		if !strings.HasPrefix(tracer.spans[i], want[i]) {
+			t.Fatalf("expected %q, got %q", want[i], tracer.spans[i])
+		}
+	}
+
+	// Trace context received from outside continues through headers.
+	tracer.spans = nil
+	res, _ := eb.Publish("invoice", 0, WithHeader("trace", "remote"))
+	res.Wait()
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	for _, span := range tracer.spans {
+		if !strings.Contains(span, " remote ") {
+			t.Fatalf("expected remote trace, got %v", tracer.spans)
+		}
+	}
+}
+
+func TestCausedBy(t *testing.T) {
+	tracer := &testTracer{}
+	eb := New[int](WithTracer(tracer), WithHandlerTimeout(time.Second))
+	var order Event[int]
+	eb.Subscribe("order", func(ev Event[int]) {
+		order = ev
+		for i := 0; i < 100; i++ {
+			eb.Publish("invoice", i, CausedBy(ev))
+		}
+	})
+	var got atomic.Int32
+	eb.Subscribe("invoice", func(ev Event[int]) {
+		if ev.CausationID != order.ID || ev.CorrelationID != order.ID {
+			t.Errorf("invoice must be caused by %q, got causation %q and correlation %q", order.ID, ev.CausationID, ev.CorrelationID)
+		}
+		if ev.Context().Value(traceKey{}) != "1" {
+			t.Errorf("invoice must continue the trace, got %v", ev.Context().Value(traceKey{}))
+		}
+		got.Add(1)
+	})
+
+	if err := eb.PublishSync("order", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := eb.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Load() != 100 {
+		t.Fatalf("follow-ups of timed handler must be delivered after it returns, got %d of 100", got.Load())
+	}
+}
+
 func BenchmarkUnsubscribe(b *testing.B) {
 	b.StopTimer()
 	b.ResetTimer()
@@ -234,7 +1819,8 @@ func BenchmarkUnsubscribe(b *testing.B) {
 		if cnt == 0 {
 			cnt = subsCount
 			for i := 0; i < cnt; i++ {
-				subs = append(subs, eb.Subscribe(strconv.FormatUint(rand.Uint64(), 16), f))
+				sub, _ := eb.Subscribe(strconv.FormatUint(rand.Uint64(), 16), f)
+				subs = append(subs, sub)
 			}
 			if eb.TotalSubscribers() != cnt {
 				b.Fatalf("Subscribe failed. Wanted %d, got %d subscribers", cnt, eb.TotalSubscribers())
@@ -267,7 +1853,7 @@ func BenchmarkEventPublish(b *testing.B) {
 	eventsGot := atomic.Int32{}
 
 	for i := 0; i < len(subs); i++ {
-		subs[i] = eb.Subscribe(topic[:i%(len(topic)-1)+1]+"*", func(ev Event[any]) {
+		subs[i], _ = eb.Subscribe(topic[:i%(len(topic)-1)+1]+"*", func(ev Event[any]) {
 			eventsGot.Add(1)
 		})
 	}
@@ -292,6 +1878,62 @@ func BenchmarkEventPublish(b *testing.B) {
 	eb.Close()
 }
 
+func BenchmarkEventPublishParallel(b *testing.B) {
+	eb := NewUntyped()
+	topics := make([]string, 64)
+	for i := range topics {
+		topics[i] = "test.event" + strconv.Itoa(i)
+		eb.Subscribe(topics[i], func(ev Event[any]) {})
+	}
+	eb.Subscribe("test.*", func(ev Event[any]) {})
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if err := eb.PublishSync(topics[i%len(topics)], nil); err != nil {
+				b.Fatal("got error", err)
+			}
+		}
+	})
+}
+
+// Same as BenchmarkEventPublishParallel, while subscriptions change concurrently.
+func BenchmarkEventPublishParallelChurn(b *testing.B) {
+	eb := NewUntyped()
+	topics := make([]string, 64)
+	for i := range topics {
+		topics[i] = "test.event" + strconv.Itoa(i)
+		eb.Subscribe(topics[i], func(ev Event[any]) {})
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sub, _ := eb.Subscribe("other"+strconv.Itoa(i%8), func(ev Event[any]) {})
+			eb.Unsubscribe(sub)
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if err := eb.PublishSync(topics[i%len(topics)], nil); err != nil {
+				b.Fatal("got error", err)
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
 func BenchmarkSubscribe(b *testing.B) {
 	b.StopTimer()
 	b.ResetTimer()
@@ -313,16 +1955,16 @@ func BenchmarkSubscribe(b *testing.B) {
 		for i := 0; i < subsCount; i += 10 {
 			j := i % len(topics)
 			b.StartTimer() // not very accurate but it takes forever to start/stop timer every time within the loop
-			subs[i+0] = eb.Subscribe(topics[j+0], h)
-			subs[i+1] = eb.Subscribe(topics[j+1], h)
-			subs[i+2] = eb.Subscribe(topics[j+2], h)
-			subs[i+3] = eb.Subscribe(topics[j+3], h)
-			subs[i+4] = eb.Subscribe(topics[j+4], h)
-			subs[i+5] = eb.Subscribe(topics[j+5], h)
-			subs[i+6] = eb.Subscribe(topics[j+6], h)
-			subs[i+7] = eb.Subscribe(topics[j+7], h)
-			subs[i+8] = eb.Subscribe(topics[j+8], h)
-			subs[i+9] = eb.Subscribe(topics[j+9], h)
+			subs[i+0], _ = eb.Subscribe(topics[j+0], h)
+			subs[i+1], _ = eb.Subscribe(topics[j+1], h)
+			subs[i+2], _ = eb.Subscribe(topics[j+2], h)
+			subs[i+3], _ = eb.Subscribe(topics[j+3], h)
+			subs[i+4], _ = eb.Subscribe(topics[j+4], h)
+			subs[i+5], _ = eb.Subscribe(topics[j+5], h)
+			subs[i+6], _ = eb.Subscribe(topics[j+6], h)
+			subs[i+7], _ = eb.Subscribe(topics[j+7], h)
+			subs[i+8], _ = eb.Subscribe(topics[j+8], h)
+			subs[i+9], _ = eb.Subscribe(topics[j+9], h)
 			b.StopTimer()
 		}
 		eb.Close()
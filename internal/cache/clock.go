@@ -0,0 +1,182 @@
+/*
+ * Bounded cache with CLOCK (second chance) eviction
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Cache statistics.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Len       int // Number of entries
+	Capacity  int
+}
+
+// Bounded string-keyed cache. When full, evicts an entry not used since the clock hand passed it last time,
+// approximating LRU. Safe for concurrent use: Get never blocks, while modifications are serialized.
+// Zero capacity disables caching.
+type Clock[V any] struct {
+	entries  sync.Map // Keys to *entry[V]
+	mu       sync.Mutex
+	ring     []*entry[V] // Slots, nil if free
+	free     []int       // Free slots in ring
+	hand     int
+	capacity int
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type entry[V any] struct {
+	key   string
+	value V
+	slot  int
+	used  atomic.Bool // Referenced since the hand passed it last time
+}
+
+func NewClock[V any](capacity int) *Clock[V] {
+	capacity = max(capacity, 0)
+	return &Clock[V]{
+		ring:     make([]*entry[V], 0, capacity),
+		capacity: capacity,
+	}
+}
+
+func (c *Clock[V]) Get(key string) (V, bool) {
+	e, ok := c.entries.Load(key)
+	if !ok {
+		c.misses.Add(1)
+		return *new(V), false
+	}
+	c.hits.Add(1)
+
+	ent := e.(*entry[V])
+	// Avoid writing shared memory on every hit.
+	if !ent.used.Load() {
+		ent.used.Store(true)
+	}
+	return ent.value, true
+}
+
+// Adds or replaces entry, evicting some other entry if cache is full.
+// To never block, skips caching if another goroutine is modifying the cache,
+// or if `valid` returns false. `valid` is called under the lock, serialized with other modifications.
+// Nil `valid` is considered true.
+func (c *Clock[V]) Put(key string, value V, valid func() bool) {
+	if c.capacity == 0 || !c.mu.TryLock() {
+		return
+	}
+	defer c.mu.Unlock()
+
+	if valid != nil && !valid() {
+		return
+	}
+
+	e := &entry[V]{key: key, value: value}
+	if old, ok := c.entries.Load(key); ok {
+		e.slot = old.(*entry[V]).slot
+	} else {
+		switch {
+		case len(c.free) > 0:
+			e.slot = c.free[len(c.free)-1]
+			c.free = c.free[:len(c.free)-1]
+		case len(c.ring) < c.capacity:
+			e.slot = len(c.ring)
+			c.ring = append(c.ring, nil)
+		default:
+			e.slot = c.evict()
+		}
+	}
+	c.ring[e.slot] = e
+	c.entries.Store(key, e)
+}
+
+// Evicts an entry and returns its slot. Cache must be full.
+func (c *Clock[V]) evict() int {
+	for {
+		e := c.ring[c.hand]
+		slot := c.hand
+		c.hand = (c.hand + 1) % len(c.ring)
+
+		if e.used.Swap(false) {
+			continue
+		}
+		c.entries.Delete(e.key)
+		c.evictions.Add(1)
+		return slot
+	}
+}
+
+func (c *Clock[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries.Load(key); ok {
+		c.remove(e.(*entry[V]))
+	}
+}
+
+// Deletes every entry for which `del` returns true.
+func (c *Clock[V]) DeleteFunc(del func(key string, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.ring {
+		if e != nil && del(e.key, e.value) {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *Clock[V]) remove(e *entry[V]) {
+	c.entries.Delete(e.key)
+	c.ring[e.slot] = nil
+	c.free = append(c.free, e.slot)
+}
+
+// Deletes all entries. Statistics are kept.
+func (c *Clock[V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.ring {
+		if e != nil {
+			c.entries.Delete(e.key)
+		}
+	}
+	c.ring = c.ring[:0]
+	c.free = c.free[:0]
+	c.hand = 0
+}
+
+func (c *Clock[V]) Stats() Stats {
+	c.mu.Lock()
+	n := len(c.ring) - len(c.free)
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Len:       n,
+		Capacity:  c.capacity,
+	}
+}
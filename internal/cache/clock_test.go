@@ -0,0 +1,78 @@
+/*
+ * Holds CLOCK cache tests
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package cache_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amanofbits/gogoevents/internal/cache"
+)
+
+func TestEvictsUnused(t *testing.T) {
+	c := cache.NewClock[int](2)
+	c.Put("a", 1, nil)
+	c.Put("b", 2, nil)
+	c.Get("a")
+	c.Put("c", 3, nil)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b must have been evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("a: got %d, %v, expected 1, true", v, ok)
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Errorf("c: got %d, %v, expected 3, true", v, ok)
+	}
+
+	stats := c.Stats()
+	expected := cache.Stats{Hits: 3, Misses: 1, Evictions: 1, Len: 2, Capacity: 2}
+	if stats != expected {
+		t.Errorf("got %+v, expected %+v", stats, expected)
+	}
+}
+
+func TestDeleteFreesSlot(t *testing.T) {
+	c := cache.NewClock[int](2)
+	c.Put("a.x", 1, nil)
+	c.Put("b.x", 2, nil)
+	c.DeleteFunc(func(key string, _ int) bool { return strings.HasPrefix(key, "a.") })
+	c.Put("c.x", 3, nil)
+
+	if _, ok := c.Get("b.x"); !ok {
+		t.Error("b.x must not have been evicted")
+	}
+	if stats := c.Stats(); stats.Evictions != 0 || stats.Len != 2 {
+		t.Errorf("got %+v, expected no evictions and 2 entries", stats)
+	}
+}
+
+func TestPutValidation(t *testing.T) {
+	c := cache.NewClock[int](1)
+	c.Put("a", 1, func() bool { return false })
+	if _, ok := c.Get("a"); ok {
+		t.Error("invalid entry must not be stored")
+	}
+}
+
+func TestZeroCapacity(t *testing.T) {
+	c := cache.NewClock[int](0)
+	c.Put("a", 1, nil)
+	if _, ok := c.Get("a"); ok {
+		t.Error("zero capacity cache must not store entries")
+	}
+}
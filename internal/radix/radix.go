@@ -0,0 +1,274 @@
+/*
+ * Immutable radix tree, indexing values by literal prefixes of their patterns
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package radix
+
+import "strings"
+
+// Radix tree of values, grouped by pattern and indexed by pattern's literal prefix.
+// Tree is immutable: Add and Remove return modified copy, sharing unchanged nodes with the original.
+// Trees derived from one another must not be modified concurrently, but may be read concurrently
+// with modification. Zero value is an empty tree.
+type Tree[V any] struct {
+	root    *node[V]
+	size    int
+	version uint64
+	latest  *uint64 // Latest version among derived trees
+}
+
+type node[V any] struct {
+	label    string     // Edge label leading to this node
+	children []*node[V] // Sorted by label's first byte
+	exact    []group[V] // Patterns without wildcards, ending at this node
+	partial  []group[V] // Patterns with literal prefix ending at this node
+}
+
+// Values sharing a pattern.
+type group[V any] struct {
+	pattern string
+	values  []V
+}
+
+// Returns number of values in the tree.
+func (t Tree[V]) Len() int {
+	return t.size
+}
+
+// Returns tree with `v` added to `pattern` group. `prefix` is pattern's literal prefix,
+// which is whole pattern if `exact`, i.e. pattern has no wildcards.
+func (t Tree[V]) Add(prefix, pattern string, exact bool, v V) Tree[V] {
+	// Nothing is derived from the latest tree yet, so values can be appended in place:
+	// other trees never look beyond their groups' length.
+	inPlace := t.latest != nil && *t.latest == t.version
+	return t.derive(t.root.add(prefix, pattern, exact, v, inPlace), t.size+1)
+}
+
+// Returns tree with the first value of `pattern` group satisfying `pred` removed, and true.
+// Returns the same tree and false if there is no such value.
+// `prefix` and `exact` must be the same as passed to Add.
+func (t Tree[V]) Remove(prefix, pattern string, exact bool, pred func(V) bool) (Tree[V], bool) {
+	root, ok := t.root.remove(prefix, pattern, exact, pred)
+	if !ok {
+		return t, false
+	}
+	return t.derive(root, t.size-1), true
+}
+
+func (t Tree[V]) derive(root *node[V], size int) Tree[V] {
+	latest := t.latest
+	if latest == nil {
+		latest = new(uint64)
+	}
+	*latest++
+	return Tree[V]{root: root, size: size, version: *latest, latest: latest}
+}
+
+// Calls `fn` for every pattern group that may match the `topic`, i.e. pattern's literal prefix is topic's prefix.
+// `exact` tells that pattern is equal to topic, so no further check is needed.
+// Groups are visited from the shortest prefix to the longest. `values` must not be modified.
+func (t Tree[V]) Walk(topic string, fn func(pattern string, values []V, exact bool)) {
+	n := t.root
+	for n != nil {
+		for _, g := range n.partial {
+			fn(g.pattern, g.values, false)
+		}
+		if topic == "" {
+			for _, g := range n.exact {
+				fn(g.pattern, g.values, true)
+			}
+			return
+		}
+
+		i, ok := n.child(topic[0])
+		if !ok || !strings.HasPrefix(topic, n.children[i].label) {
+			return
+		}
+		topic = topic[len(n.children[i].label):]
+		n = n.children[i]
+	}
+}
+
+// Returns index of the child with label starting with `c`, or index to insert such child at.
+func (n *node[V]) child(c byte) (int, bool) {
+	lo, hi := 0, len(n.children)
+	for lo < hi {
+		h := int(uint(lo+hi) >> 1)
+		if n.children[h].label[0] < c {
+			lo = h + 1
+		} else {
+			hi = h
+		}
+	}
+	return lo, lo < len(n.children) && n.children[lo].label[0] == c
+}
+
+// Returns shallow copy of the node, safe to modify. Nil node is copied as empty.
+func (n *node[V]) clone() *node[V] {
+	if n == nil {
+		return &node[V]{}
+	}
+	c := *n
+	c.children = append([]*node[V](nil), n.children...)
+	return &c
+}
+
+func (n *node[V]) add(key, pattern string, exact bool, v V, inPlace bool) *node[V] {
+	c := n.clone()
+	if key == "" {
+		if exact {
+			c.exact = addToGroup(c.exact, pattern, v, inPlace)
+		} else {
+			c.partial = addToGroup(c.partial, pattern, v, inPlace)
+		}
+		return c
+	}
+
+	i, ok := c.child(key[0])
+	if !ok {
+		leaf := (&node[V]{label: key}).add("", pattern, exact, v, false)
+		c.children = append(c.children, nil)
+		copy(c.children[i+1:], c.children[i:])
+		c.children[i] = leaf
+		return c
+	}
+
+	child := c.children[i]
+	common := commonPrefixLen(child.label, key)
+	if common == len(child.label) {
+		c.children[i] = child.add(key[common:], pattern, exact, v, inPlace)
+		return c
+	}
+
+	// Split the edge.
+	tail := *child
+	tail.label = child.label[common:]
+	mid := &node[V]{label: child.label[:common], children: []*node[V]{&tail}}
+	c.children[i] = mid.add(key[common:], pattern, exact, v, false)
+	return c
+}
+
+func (n *node[V]) remove(key, pattern string, exact bool, pred func(V) bool) (*node[V], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	if key == "" {
+		groups := n.partial
+		if exact {
+			groups = n.exact
+		}
+		groups, ok := removeFromGroup(groups, pattern, pred)
+		if !ok {
+			return n, false
+		}
+		c := n.clone()
+		if exact {
+			c.exact = groups
+		} else {
+			c.partial = groups
+		}
+		return c.compact(), true
+	}
+
+	i, ok := n.child(key[0])
+	if !ok || !strings.HasPrefix(key, n.children[i].label) {
+		return n, false
+	}
+	child, ok := n.children[i].remove(key[len(n.children[i].label):], pattern, exact, pred)
+	if !ok {
+		return n, false
+	}
+
+	c := n.clone()
+	if child == nil {
+		c.children = append(c.children[:i], c.children[i+1:]...)
+	} else {
+		c.children[i] = child
+	}
+	return c.compact(), true
+}
+
+// Removes useless node: returns nil if it's empty, or merges it with its only child.
+// Root has empty label, so it's never merged, only emptied.
+func (n *node[V]) compact() *node[V] {
+	if len(n.exact) > 0 || len(n.partial) > 0 {
+		return n
+	}
+	switch len(n.children) {
+	case 0:
+		return nil
+	case 1:
+		if n.label == "" {
+			return n
+		}
+		child := *n.children[0]
+		child.label = n.label + child.label
+		return &child
+	default:
+		return n
+	}
+}
+
+// Returns groups with `v` appended to `pattern` group. Doesn't modify original groups,
+// but if `inPlace`, may use spare capacity of their values.
+func addToGroup[V any](groups []group[V], pattern string, v V, inPlace bool) []group[V] {
+	groups = append([]group[V](nil), groups...)
+	for i := range groups {
+		if groups[i].pattern != pattern {
+			continue
+		}
+		values := groups[i].values
+		if !inPlace {
+			values = values[:len(values):len(values)]
+		}
+		groups[i].values = append(values, v)
+		return groups
+	}
+	return append(groups, group[V]{pattern: pattern, values: []V{v}})
+}
+
+// Returns groups without the first value in `pattern` group satisfying `pred`. Doesn't modify original groups.
+func removeFromGroup[V any](groups []group[V], pattern string, pred func(V) bool) ([]group[V], bool) {
+	for i := range groups {
+		if groups[i].pattern != pattern {
+			continue
+		}
+		for j, v := range groups[i].values {
+			if !pred(v) {
+				continue
+			}
+			groups = append([]group[V](nil), groups...)
+			if len(groups[i].values) == 1 {
+				return append(groups[:i], groups[i+1:]...), true
+			}
+			values := make([]V, 0, len(groups[i].values)-1)
+			values = append(values, groups[i].values[:j]...)
+			groups[i].values = append(values, groups[i].values[j+1:]...)
+			return groups, true
+		}
+		return groups, false
+	}
+	return groups, false
+}
+
+func commonPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+/*
+ * Holds radix tree tests
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package radix_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/amanofbits/gogoevents/internal/radix"
+)
+
+// Adds glob-like patterns, where '*' ends literal prefix.
+func add(t radix.Tree[int], pattern string, v int) radix.Tree[int] {
+	prefix, _, found := strings.Cut(pattern, "*")
+	return t.Add(prefix, pattern, !found, v)
+}
+
+func remove(t radix.Tree[int], pattern string, v int) (radix.Tree[int], bool) {
+	prefix, _, found := strings.Cut(pattern, "*")
+	return t.Remove(prefix, pattern, !found, func(x int) bool { return x == v })
+}
+
+func walk(t radix.Tree[int], topic string) []string {
+	got := []string{}
+	t.Walk(topic, func(pattern string, values []int, exact bool) {
+		for range values {
+			got = append(got, pattern)
+		}
+	})
+	slices.Sort(got)
+	return got
+}
+
+func TestWalk(t *testing.T) {
+	tree := radix.Tree[int]{}
+	patterns := []string{"*", "test", "te*", "test*", "tea", "tea*", "other*", "testevent", "test"}
+	for i, p := range patterns {
+		tree = add(tree, p, i)
+	}
+	if tree.Len() != len(patterns) {
+		t.Fatalf("expected %d values, got %d", len(patterns), tree.Len())
+	}
+
+	cases := map[string][]string{
+		"test":      {"*", "te*", "test", "test", "test*"},
+		"tea":       {"*", "te*", "tea", "tea*"},
+		"testevent": {"*", "te*", "test*", "testevent"},
+		"x":         {"*"},
+		"":          {"*"},
+	}
+	for topic, want := range cases {
+		if got := walk(tree, topic); !slices.Equal(got, want) {
+			t.Fatalf("%q: expected %v, got %v", topic, want, got)
+		}
+	}
+}
+
+func TestRemoveKeepsOriginal(t *testing.T) {
+	tree := radix.Tree[int]{}
+	patterns := []string{"te*", "test", "tea", "testevent"}
+	for i, p := range patterns {
+		tree = add(tree, p, i)
+	}
+
+	removed := tree
+	for i, p := range patterns {
+		var ok bool
+		if removed, ok = remove(removed, p, i); !ok {
+			t.Fatalf("%q: not removed", p)
+		}
+	}
+	if _, ok := remove(removed, "te*", 0); ok {
+		t.Fatalf("removed twice")
+	}
+	if removed.Len() != 0 || len(walk(removed, "testevent")) != 0 {
+		t.Fatalf("expected empty tree, got %d values", removed.Len())
+	}
+
+	if got := walk(tree, "testevent"); !slices.Equal(got, []string{"te*", "testevent"}) {
+		t.Fatalf("original tree modified: %v", got)
+	}
+}
+
+func TestAddToOldTree(t *testing.T) {
+	base := add(add(radix.Tree[int]{}, "test", 1), "test", 2)
+	a := add(base, "test", 3)
+	b := add(base, "test", 4) // must not overwrite value added to a
+
+	var got []int
+	a.Walk("test", func(pattern string, values []int, exact bool) {
+		got = append(got, values...)
+	})
+	if !slices.Equal(got, []int{1, 2, 3}) {
+		t.Fatalf("expected [1 2 3], got %v", got)
+	}
+	if b.Len() != 3 || base.Len() != 2 {
+		t.Fatalf("bad lengths: base %d, b %d", base.Len(), b.Len())
+	}
+}
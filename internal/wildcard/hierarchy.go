@@ -0,0 +1,145 @@
+/*
+ * Hierarchical (MQTT/AMQP-like) topic matching
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package wildcard
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Characters that can't appear in a hierarchical topic, nor in its separator.
+const HierarchyWildcards = "*+#>"
+
+// Hierarchical topics consist of levels, divided by separator.
+// Pattern level may be a single-level wildcard, '+' or '*', matching exactly one level,
+// or, if it's the last level, multi-level wildcard, '#' or '>', matching any number of trailing levels, including none.
+type Hierarchy struct {
+	Sep string
+}
+
+func isSingleLevel(level string) bool {
+	return level == "+" || level == "*"
+}
+
+func isMultiLevel(level string) bool {
+	return level == "#" || level == ">"
+}
+
+// Returns error if pattern is malformed: it's empty, has empty levels,
+// has wildcards mixed with other characters in one level, or multi-level wildcard is not the last level.
+func (h Hierarchy) Validate(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty pattern")
+	}
+
+	var level string
+	for i, more := 0, true; more; i++ {
+		level, pattern, more = strings.Cut(pattern, h.Sep)
+		switch {
+		case level == "":
+			return fmt.Errorf("level %d is empty", i)
+		case isMultiLevel(level) && more:
+			return fmt.Errorf("multi-level wildcard %q at level %d is not the last level", level, i)
+		case len(level) > 1 && strings.ContainsAny(level, HierarchyWildcards):
+			return fmt.Errorf("level %d (%q) mixes wildcard with other characters", i, level)
+		}
+	}
+	return nil
+}
+
+// Returns index of first wildcard symbol in topic, or -1 if not found.
+func (h Hierarchy) Index(topic string) int {
+	return strings.IndexAny(topic, HierarchyWildcards)
+}
+
+// Returns literal prefix of valid pattern: all levels before the first wildcard level.
+// Returned prefix includes trailing separator if the wildcard requires a level.
+// `exact` is true if pattern has no wildcards, prefix is the whole pattern then.
+func (h Hierarchy) LiteralPrefix(pattern string) (prefix string, exact bool) {
+	for i := 0; i < len(pattern); {
+		end := strings.Index(pattern[i:], h.Sep)
+		if end < 0 {
+			end = len(pattern)
+		} else {
+			end += i
+		}
+
+		switch level := pattern[i:end]; {
+		case isSingleLevel(level):
+			return pattern[:i], false
+		case isMultiLevel(level):
+			// Multi-level wildcard also matches no levels, so separator before it is optional.
+			return pattern[:max(i-len(h.Sep), 0)], false
+		}
+		i = end + len(h.Sep)
+	}
+	return pattern, true
+}
+
+// Compiled hierarchical pattern.
+type HierarchyPattern struct {
+	pattern string
+	sep     string
+	levels  []string
+	prefix  string
+	exact   bool
+}
+
+// Validates and parses pattern, see Validate.
+func (h Hierarchy) Compile(pattern string) (*HierarchyPattern, error) {
+	if err := h.Validate(pattern); err != nil {
+		return nil, err
+	}
+	p := &HierarchyPattern{pattern: pattern, sep: h.Sep, levels: strings.Split(pattern, h.Sep)}
+	p.prefix, p.exact = h.LiteralPrefix(pattern)
+	return p, nil
+}
+
+func (p *HierarchyPattern) String() string {
+	return p.pattern
+}
+
+// See Hierarchy.LiteralPrefix.
+func (p *HierarchyPattern) LiteralPrefix() (prefix string, exact bool) {
+	return p.prefix, p.exact
+}
+
+// Match returns true if the pattern matches the topic.
+func (p *HierarchyPattern) Match(topic string) bool {
+	if p.exact {
+		return topic == p.prefix
+	}
+
+	var tLevel string
+	tMore := true
+	for i, pLevel := range p.levels {
+		if isMultiLevel(pLevel) {
+			return true
+		}
+		if !tMore {
+			return false
+		}
+		tLevel, topic, tMore = strings.Cut(topic, p.sep)
+		if pLevel != tLevel && !isSingleLevel(pLevel) {
+			return false
+		}
+		if i == len(p.levels)-1 {
+			return !tMore
+		}
+	}
+	return false
+}
@@ -1,15 +1,9 @@
 /*
- * Wildcard string matching algorithm, taken and slightly modified from:
- * https://github.com/IGLOU-EU/go-wildcard/blob/2f93770ccbe7d1f3e102221d88ade4c0ecca52be/wildcard.go
+ * Glob pattern compilation and matching
  *
- * Original code copyright:
- *
- * Copyright (c) 2023 Iglou.eu <contact@iglou.eu>
- * Copyright (c) 2023 Adrien Kara <adrien@iglou.eu>
+ * Copyright © 2023 amanofbits
  *
  * This file is part of gogoevents.
- * modifications copyright:
- * © 2023 amanofbits
  *
  * gogoevents is free software: you can redistribute it and/or modify
  * it under the terms of the BSD 3-Clause License as published by the
@@ -21,101 +15,467 @@
 
 package wildcard
 
-// Match returns true if the pattern matches the s string.
-// The pattern can contain the wildcard character '*'.
-func Match(pattern, s string) bool {
-	if pattern == "" {
-		return s == pattern
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits number of alternatives a pattern expands to, e.g. "{a,b}{c,d}" expands to 4.
+const MaxAlternatives = 256
+
+// Compiled glob pattern. Pattern may contain:
+//   - '*', matching any characters, including none;
+//   - '?', matching exactly one character;
+//   - character class, matching one character from the set: "[abc]", "[a-z0-9]",
+//     or not from the set, if it starts with '!' or '^': "[!abc]";
+//   - alternation, matching any of comma-separated subpatterns: "{created,updated}", "{*.eu,*.us}";
+//   - '\', escaping next character, so that it matches literally: "\*", "\{", "\\".
+//
+// Any other character matches itself. Characters are runes of UTF-8 encoded string.
+type Glob struct {
+	pattern string // Normalized pattern
+	alts    []glob // Alternatives with braces expanded
+	prefix  string // Literal prefix common to all alternatives
+	exact   bool
+}
+
+// Pattern without alternation, i.e. fixed-length segments divided by stars.
+type glob struct {
+	segs     []segment
+	anchored bool // No stars, the only segment must match the whole string
+}
+
+// Sequence of single-character matchers.
+type segment []piece
+
+// Either literal run or single-character matcher.
+type piece struct {
+	lit   string
+	class *class // If nil and `lit` is empty, matches any character
+}
+
+type class struct {
+	ranges  []runeRange
+	negated bool
+}
+
+type runeRange struct {
+	lo, hi rune
+}
+
+func (c *class) match(r rune) bool {
+	for _, rr := range c.ranges {
+		if rr.lo <= r && r <= rr.hi {
+			return !c.negated
+		}
 	}
-	if pattern == "*" || s == pattern {
-		return true
+	return c.negated
+}
+
+// Parses pattern. Returns error if it's malformed: has unclosed class or alternation,
+// unmatched '}', empty class, reversed range, trailing '\', or too many alternatives.
+func Compile(pattern string) (*Glob, error) {
+	if !strings.ContainsAny(pattern, "?[{}\\") {
+		return compileStars(pattern), nil
+	}
+
+	p := parser{src: pattern}
+	alts, err := p.parseSeq(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unmatched '}' at %d", p.pos)
 	}
 
-	return match(pattern, s)
+	g := &Glob{pattern: p.norm.String(), alts: make([]glob, len(alts))}
+	for i, alt := range alts {
+		g.alts[i] = build(alt)
+		prefix, exact := alt.literalPrefix()
+		if i == 0 {
+			g.prefix, g.exact = prefix, exact
+			continue
+		}
+		g.prefix = g.prefix[:commonPrefixLen(g.prefix, prefix)]
+		g.exact = false
+	}
+	return g, nil
 }
 
-func match(pattern, s string) bool {
-	var patternIndex, sIndex, lastStar int
-	patternLen := len(pattern)
-	sLen := len(s)
-	star := -1
+// Compiles the most common patterns, consisting of literals and stars only.
+func compileStars(pattern string) *Glob {
+	var norm strings.Builder
+	var g glob
+	lits := strings.Split(pattern, "*")
+	for i, lit := range lits {
+		if i > 0 {
+			if lit == "" && i < len(lits)-1 {
+				continue // Consecutive stars
+			}
+			norm.WriteByte('*')
+		}
+		norm.WriteString(lit)
 
-Loop:
-	if sIndex >= sLen {
-		goto checkPattern
+		var seg segment
+		if lit != "" {
+			seg = segment{{lit: lit}}
+		}
+		g.segs = append(g.segs, seg)
 	}
+	g.anchored = len(g.segs) == 1
+	prefix, _, _ := strings.Cut(pattern, "*")
+	return &Glob{pattern: norm.String(), alts: []glob{g}, prefix: prefix, exact: g.anchored}
+}
 
-	if patternIndex >= patternLen {
-		if star != -1 {
-			patternIndex = star + 1
-			lastStar++
-			sIndex = lastStar
-			goto Loop
+// Returns normalized pattern: same as compiled, but with consecutive stars collapsed.
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+// Returns the part every matching string starts with.
+// `exact` is true if pattern has no wildcards and alternatives, prefix is the only matching string then.
+func (g *Glob) LiteralPrefix() (prefix string, exact bool) {
+	return g.prefix, g.exact
+}
+
+// Match returns true if the pattern matches the s string.
+func (g *Glob) Match(s string) bool {
+	if g.exact {
+		return s == g.prefix
+	}
+	if !strings.HasPrefix(s, g.prefix) {
+		return false
+	}
+	for i := range g.alts {
+		if g.alts[i].match(s) {
+			return true
 		}
+	}
+	return false
+}
+
+func (g *glob) match(s string) bool {
+	if g.anchored {
+		n, ok := g.segs[0].matchPrefix(s)
+		return ok && n == len(s)
+	}
+
+	// Segments before the first and after the last star are anchored at the ends.
+	first, last := g.segs[0], g.segs[len(g.segs)-1]
+	n, ok := last.matchSuffix(s)
+	if !ok {
 		return false
 	}
-	switch pattern[patternIndex] {
-	case '*':
-		// '*' matches zero or more characters. Store its position and increment the pattern index.
-		star = patternIndex
-		lastStar = sIndex
-		patternIndex++
-		goto Loop
-	default:
-		// If the characters don't match, check if there was a previous '*' to backtrack.
-		if pattern[patternIndex] != s[sIndex] {
-			if star != -1 {
-				patternIndex = star + 1
-				lastStar++
-				sIndex = lastStar
-				goto Loop
-			}
+	s = s[:len(s)-n]
+	if n, ok = first.matchPrefix(s); !ok {
+		return false
+	}
+	s = s[n:]
 
+	// Middle segments are fixed-length, so taking the leftmost occurrence never prevents a match.
+	for _, seg := range g.segs[1 : len(g.segs)-1] {
+		if s, ok = seg.find(s); !ok {
 			return false
 		}
 	}
+	return true
+}
+
+// Returns number of bytes of `s` matched by the segment at its start.
+func (seg segment) matchPrefix(s string) (int, bool) {
+	n := 0
+	for _, p := range seg {
+		if p.lit != "" {
+			if !strings.HasPrefix(s[n:], p.lit) {
+				return 0, false
+			}
+			n += len(p.lit)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if size == 0 || (p.class != nil && !p.class.match(r)) {
+			return 0, false
+		}
+		n += size
+	}
+	return n, true
+}
 
-	patternIndex++
-	sIndex++
-	goto Loop
+// Returns number of bytes of `s` matched by the segment at its end.
+func (seg segment) matchSuffix(s string) (int, bool) {
+	end := len(s)
+	for i := len(seg) - 1; i >= 0; i-- {
+		p := seg[i]
+		if p.lit != "" {
+			if !strings.HasSuffix(s[:end], p.lit) {
+				return 0, false
+			}
+			end -= len(p.lit)
+			continue
+		}
+		r, size := utf8.DecodeLastRuneInString(s[:end])
+		if size == 0 || (p.class != nil && !p.class.match(r)) {
+			return 0, false
+		}
+		end -= size
+	}
+	return len(s) - end, true
+}
 
-	// Check if the remaining pattern characters is '*', which can match the end of the string.
-checkPattern:
-	if patternIndex < patternLen {
-		if pattern[patternIndex] == '*' {
-			patternIndex++
-			goto checkPattern
+// Returns the rest of `s` after the leftmost segment occurrence.
+func (seg segment) find(s string) (string, bool) {
+	for i := 0; i <= len(s); {
+		if n, ok := seg.matchPrefix(s[i:]); ok {
+			return s[i+n:], true
 		}
+		if i == len(s) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
 	}
+	return "", false
+}
 
-	return patternIndex == patternLen
+// Pattern item before brace expansion.
+type item struct {
+	star bool
+	lit  rune
+	any  bool
+	cls  *class
 }
 
-// Returns index of first wildcard symbol, or -1 if not found.
-func Index(s string) int {
-	for i, r := range []rune(s) {
-		if r == '*' {
-			return i
+type sequence []item
+
+func (seq sequence) literalPrefix() (string, bool) {
+	var b strings.Builder
+	for _, it := range seq {
+		if it.star || it.any || it.cls != nil {
+			return b.String(), false
+		}
+		b.WriteRune(it.lit)
+	}
+	return b.String(), true
+}
+
+// Splits sequence into segments by stars, merging literal runs.
+func build(seq sequence) glob {
+	g := glob{anchored: true}
+	var seg segment
+	var lit strings.Builder
+
+	flush := func() {
+		if lit.Len() > 0 {
+			seg = append(seg, piece{lit: lit.String()})
+			lit.Reset()
+		}
+	}
+	for _, it := range seq {
+		switch {
+		case it.star:
+			flush()
+			g.segs = append(g.segs, seg)
+			seg, g.anchored = nil, false
+		case it.any:
+			flush()
+			seg = append(seg, piece{})
+		case it.cls != nil:
+			flush()
+			seg = append(seg, piece{class: it.cls})
+		default:
+			lit.WriteRune(it.lit)
 		}
 	}
-	return -1
+	flush()
+	g.segs = append(g.segs, seg)
+	return g
 }
 
-// Removes redundand consecutive wildcards and returns modified string
-func Normalize(s string) string {
-	if len(s) < 2 {
-		return s
+type parser struct {
+	src  string
+	pos  int
+	norm strings.Builder // Normalized source
+	star bool            // Last written item is a star
+}
+
+// Parses items up to the end, or up to ',' or '}' if `nested`, and returns expanded alternatives.
+func (p *parser) parseSeq(nested bool) ([]sequence, error) {
+	seqs := []sequence{nil}
+	appendItem := func(it item) {
+		for i := range seqs {
+			// Consecutive stars are redundant.
+			if it.star && len(seqs[i]) > 0 && seqs[i][len(seqs[i])-1].star {
+				continue
+			}
+			seqs[i] = append(seqs[i], it)
+		}
 	}
-	sr := []rune(s)
-	for i, j := 1, -1; i < len(sr); i++ {
-		if sr[i] != '*' || sr[i-1] != '*' {
+
+	for p.pos < len(p.src) {
+		start := p.pos
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+
+		switch r {
+		case ',', '}':
+			if nested {
+				return seqs, nil
+			}
+			if r == '}' {
+				return nil, fmt.Errorf("unmatched '}' at %d", p.pos)
+			}
+			p.pos += size
+			appendItem(item{lit: r})
+
+		case '*':
+			p.pos += size
+			if p.star {
+				continue
+			}
+			appendItem(item{star: true})
+
+		case '?':
+			p.pos += size
+			appendItem(item{any: true})
+
+		case '\\':
+			p.pos += size
+			if p.pos == len(p.src) {
+				return nil, errors.New("trailing '\\'")
+			}
+			lit, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			p.pos += size
+			appendItem(item{lit: lit})
+
+		case '[':
+			cls, err := p.parseClass()
+			if err != nil {
+				return nil, err
+			}
+			appendItem(item{cls: cls})
+
+		case '{':
+			alts, err := p.parseAlts()
+			if err != nil {
+				return nil, err
+			}
+			if len(seqs)*len(alts) > MaxAlternatives {
+				return nil, fmt.Errorf("more than %d alternatives", MaxAlternatives)
+			}
+			product := make([]sequence, 0, len(seqs)*len(alts))
+			for _, seq := range seqs {
+				for _, alt := range alts {
+					product = append(product, append(seq[:len(seq):len(seq)], alt...))
+				}
+			}
+			seqs = product
+			// Source of alternation is already written.
 			continue
+
+		default:
+			p.pos += size
+			appendItem(item{lit: r})
 		}
-		for j = i + 1; j < len(sr) && sr[j] == '*'; {
-			j++
+
+		p.norm.WriteString(p.src[start:p.pos])
+		p.star = r == '*'
+	}
+
+	if nested {
+		return nil, errors.New("unclosed '{'")
+	}
+	return seqs, nil
+}
+
+// Parses "{alt,alt...}" and returns alternatives.
+func (p *parser) parseAlts() ([]sequence, error) {
+	var alts []sequence
+	p.norm.WriteByte('{')
+	p.pos++
+
+	for {
+		p.star = false
+		seqs, err := p.parseSeq(true)
+		if err != nil {
+			return nil, err
+		}
+		if len(alts)+len(seqs) > MaxAlternatives {
+			return nil, fmt.Errorf("more than %d alternatives", MaxAlternatives)
+		}
+		alts = append(alts, seqs...)
+
+		sep := p.src[p.pos]
+		p.norm.WriteByte(sep)
+		p.pos++
+		if sep == '}' {
+			p.star = false
+			return alts, nil
+		}
+	}
+}
+
+// Parses "[...]" class.
+func (p *parser) parseClass() (*class, error) {
+	start := p.pos
+	p.pos++
+	cls := &class{}
+	if p.pos < len(p.src) && (p.src[p.pos] == '!' || p.src[p.pos] == '^') {
+		cls.negated = true
+		p.pos++
+	}
+
+	for {
+		if p.pos == len(p.src) {
+			return nil, fmt.Errorf("unclosed '[' at %d", start)
+		}
+		if p.src[p.pos] == ']' {
+			p.pos++
+			break
+		}
+
+		lo, err := p.classRune()
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if p.pos+1 < len(p.src) && p.src[p.pos] == '-' && p.src[p.pos+1] != ']' {
+			p.pos++
+			if hi, err = p.classRune(); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("reversed range %q-%q at %d", lo, hi, start)
+			}
+		}
+		cls.ranges = append(cls.ranges, runeRange{lo, hi})
+	}
+
+	if len(cls.ranges) == 0 {
+		return nil, fmt.Errorf("empty class at %d", start)
+	}
+	return cls, nil
+}
+
+// Reads possibly escaped rune of a class.
+func (p *parser) classRune() (rune, error) {
+	if p.src[p.pos] == '\\' {
+		p.pos++
+		if p.pos == len(p.src) {
+			return 0, errors.New("trailing '\\'")
+		}
+	}
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	return r, nil
+}
+
+func commonPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
 		}
-		sr = append(sr[:i], sr[j:]...)
-		i--
 	}
-	return string(sr)
+	return n
 }
@@ -34,6 +34,8 @@ func TestNormalize(t *testing.T) {
 		"**",
 		"*big*test*",
 		"**big******test***2*2",
+		"\\***",
+		"{**,a}[*]*",
 	}
 	ans := []string{
 		"",
@@ -42,12 +44,167 @@ func TestNormalize(t *testing.T) {
 		"*",
 		"*big*test*",
 		"*big*test*2*2",
+		"\\**",
+		"{*,a}[*]*",
 	}
 
 	for i := range s {
-		a := wildcard.Normalize(s[i])
-		if ans[i] != a {
+		g, err := wildcard.Compile(s[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := g.String(); ans[i] != a {
 			t.Fatalf("expected %s, got %s", ans[i], a)
 		}
 	}
 }
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "a-b-b-c", true},
+		{"a*b*c", "a-c-b", false},
+		{"a*a", "a", false},
+		{"?", "é", true},
+		{"?", "", false},
+		{"??", "é", false},
+		{"t?st", "test", true},
+		{"*.[a-c]", "x.b", true},
+		{"*.[a-c]", "x.d", false},
+		{"[!a-c]x", "dx", true},
+		{"[^a-c]x", "bx", false},
+		{`[a\]]`, "]", true},
+		{"orders.{created,updated}", "orders.updated", true},
+		{"orders.{created,updated}", "orders.deleted", false},
+		{"{*.eu,*.us}", "orders.us", true},
+		{"{a,b{c,d}}", "bd", true},
+		{"x{,s}", "x", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\?`, "a?", true},
+		{`\{a,b\}`, "{a,b}", true},
+		{`*\\`, `a\`, true},
+		{"*a?c*?", "xxabcabcd", true},
+	}
+
+	for _, c := range cases {
+		g, err := wildcard.Compile(c.pattern)
+		if err != nil {
+			t.Fatalf("%q: %v", c.pattern, err)
+		}
+		if got := g.Match(c.s); got != c.want {
+			t.Fatalf("Match(%q, %q): expected %v, got %v", c.pattern, c.s, c.want, got)
+		}
+	}
+}
+
+func TestGlobCompileErrors(t *testing.T) {
+	for _, p := range []string{"a\\", "[abc", "[]", "[z-a]", "{a,b", "a}", "a{b}}", "{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}"} {
+		if _, err := wildcard.Compile(p); err == nil {
+			t.Fatalf("expected %q to be invalid", p)
+		}
+	}
+}
+
+func TestGlobLiteralPrefix(t *testing.T) {
+	cases := []struct {
+		pattern, prefix string
+		exact           bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.*", "orders.", false},
+		{`orders.\*`, "orders.*", true},
+		{"orders.{created,cancelled}", "orders.c", false},
+		{"orders.{eu}", "orders.eu", true},
+		{"ord?rs", "ord", false},
+	}
+
+	for _, c := range cases {
+		g, err := wildcard.Compile(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix, exact := g.LiteralPrefix()
+		if prefix != c.prefix || exact != c.exact {
+			t.Fatalf("%q: expected (%q, %v), got (%q, %v)", c.pattern, c.prefix, c.exact, prefix, exact)
+		}
+	}
+}
+
+func TestHierarchyMatch(t *testing.T) {
+	h := wildcard.Hierarchy{Sep: "."}
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.created.eu", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.+.created", "orders.eu.created", true},
+		{"orders.+.created", "orders.created", false},
+		{"orders.#", "orders", true},
+		{"orders.#", "orders.eu.created", true},
+		{"orders.>", "orders.eu", true},
+		{"orders.>", "ordersx", false},
+		{"#", "anything.at.all", true},
+		{"+", "one", true},
+		{"+", "one.two", false},
+		{"+.+", "one", false},
+	}
+
+	for _, c := range cases {
+		p, err := h.Compile(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Match(c.topic); got != c.want {
+			t.Fatalf("Match(%q, %q): expected %v, got %v", c.pattern, c.topic, c.want, got)
+		}
+	}
+}
+
+func TestHierarchyValidate(t *testing.T) {
+	h := wildcard.Hierarchy{Sep: "/"}
+	valid := []string{"a", "a/b", "a/+/c", "a/*", "a/#", "+/>", "#"}
+	invalid := []string{"", "/a", "a/", "a//b", "a/#/c", ">/a", "a/b*", "a/+b", "a#"}
+
+	for _, p := range valid {
+		if err := h.Validate(p); err != nil {
+			t.Fatalf("expected %q to be valid, got %v", p, err)
+		}
+	}
+	for _, p := range invalid {
+		if err := h.Validate(p); err == nil {
+			t.Fatalf("expected %q to be invalid", p)
+		}
+	}
+}
+
+func TestHierarchyLiteralPrefix(t *testing.T) {
+	h := wildcard.Hierarchy{Sep: "::"}
+	cases := []struct {
+		pattern, prefix string
+		exact           bool
+	}{
+		{"a::b", "a::b", true},
+		{"a::+::c", "a::", false},
+		{"a::b::#", "a::b", false},
+		{"#", "", false},
+		{"*::b", "", false},
+	}
+
+	for _, c := range cases {
+		prefix, exact := h.LiteralPrefix(c.pattern)
+		if prefix != c.prefix || exact != c.exact {
+			t.Fatalf("%q: expected (%q, %v), got (%q, %v)", c.pattern, c.prefix, c.exact, prefix, exact)
+		}
+	}
+}
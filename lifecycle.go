@@ -0,0 +1,138 @@
+/*
+ * Holds bus lifecycle
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/amanofbits/gogoevents/internal/radix"
+)
+
+var ErrClosed = errors.New("bus is closed")
+
+// Returned by Shutdown if some deliveries didn't complete before the context was done.
+type ShutdownError struct {
+	Running int   // Handlers still running
+	Queued  int   // Deliveries still waiting in dispatcher or mailboxes
+	Err     error // Context error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown: abandoned %d running and %d queued deliveries: %v", e.Running, e.Queued, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// Counts deliveries in flight, so that Shutdown can wait for them.
+type inflight struct {
+	pending  atomic.Int64 // Matched, but not yet completed deliveries
+	running  atomic.Int64 // Handlers being called
+	closing  atomic.Bool
+	drained  chan struct{} // Closed once pending is zero after closing
+	drainOne sync.Once
+}
+
+func (f *inflight) add(n int) {
+	f.pending.Add(int64(n))
+}
+
+func (f *inflight) done() {
+	f.release(1)
+}
+
+// Completes `n` deliveries at once.
+func (f *inflight) release(n int) {
+	if f.pending.Add(-int64(n)) == 0 && f.closing.Load() {
+		f.drainOne.Do(func() { close(f.drained) })
+	}
+}
+
+// Must be called once, when no more deliveries can be added.
+func (f *inflight) close() {
+	f.closing.Store(true)
+	if f.pending.Load() == 0 {
+		f.drainOne.Do(func() { close(f.drained) })
+	}
+}
+
+// Closes the bus and waits for in-flight and queued deliveries to complete, or for `ctx` to be done.
+//
+// After the call starts, Publish* and Subscribe* return `ErrClosed`, subscriptions are removed,
+// and subscriber mailboxes are drained. Every other method is a no-op: Unsubscribe returns false,
+// TotalSubscribers returns zero, and Set* methods don't change anything.
+// Hooks (unhandled sink, panic handler etc.) stay in place for deliveries being drained.
+//
+// Returns `*ShutdownError` if `ctx` is done first. Abandoned handlers are not stopped, but nothing waits for them.
+// Returns `ErrClosed` if the bus is already closed.
+// Bus doesn't own its dispatcher, so it's up to the caller to close one after Shutdown.
+func (b *Bus[EData]) Shutdown(ctx context.Context) error {
+	if err := b.close(); err != nil {
+		return err
+	}
+
+	select {
+	case <-b.inflight.drained:
+		return nil
+	case <-ctx.Done():
+		running := b.inflight.running.Load()
+		return &ShutdownError{
+			Running: int(running),
+			Queued:  int(max(b.inflight.pending.Load()-running, 0)),
+			Err:     ctx.Err(),
+		}
+	}
+}
+
+// Closes the bus without waiting for in-flight deliveries. See Shutdown for details.
+// Returns `ErrClosed` if the bus is already closed.
+func (b *Bus[EData]) Close() error {
+	return b.close()
+}
+
+func (b *Bus[EData]) close() error {
+	b.mu.Lock()
+	closed := !b.update(func(s *snapshot[EData]) {
+		s.closed = true
+		s.index = radix.Tree[Subscriber[EData]]{}
+		s.regexps = radix.Tree[Subscriber[EData]]{}
+	})
+	if closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	subs := b.subs
+
+	b.topicCache.Clear()
+	b.subs = map[uint64]Subscriber[EData]{}
+	close(b.closed)
+	b.mu.Unlock()
+
+	b.inflight.close()
+
+	// Outside the lock, as handlers still draining the mailboxes may call the bus.
+	for _, sub := range subs {
+		if sub.mailbox != nil {
+			sub.mailbox.stop()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+ * Holds structured logging of bus events
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Logs bus lifecycle and failures to `logger`:
+//   - subscribe and unsubscribe, at Debug level;
+//   - unhandled events, at Info level;
+//   - slow handlers (see SetWatchdog) and dropped deliveries (see ReasonDropped), at Warn level;
+//   - handler panics, at Error level, with the stack trace.
+//
+// Records carry "topic", "event_id", "pattern" and "subscriber_id" attributes, whichever apply.
+// Records about events are logged with the context they were published with. Records of levels disabled
+// by the logger are skipped before they're built, so Debug records cost little in production.
+// Unhandled events, slow handlers and dropped deliveries may be numerous, see WithLogSampling.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Logs only the first of every `every` unhandled events, slow handlers and dropped deliveries, each kind counted apart.
+// Sampled records carry "count" attribute, the number of such occurrences so far. 1 or less (default) logs all of them.
+func WithLogSampling(every int) Option {
+	return func(o *options) {
+		o.logSampling = every
+	}
+}
+
+type eventLogger struct {
+	logger    *slog.Logger
+	every     uint64
+	unhandled atomic.Uint64
+	slow      atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newEventLogger(logger *slog.Logger, every int) *eventLogger {
+	if logger == nil {
+		return nil
+	}
+	return &eventLogger{logger: logger, every: uint64(max(every, 1))}
+}
+
+// Logs record about event and/or subscriber, if the level is enabled. Sampled if `counter` isn't nil.
+func logRecord[EData any](l *eventLogger, level slog.Level, counter *atomic.Uint64, msg string,
+	sub *Subscriber[EData], ev *Event[EData], extra ...slog.Attr) {
+	ctx := context.Background()
+	if ev != nil && ev.ctx != nil {
+		ctx = ev.ctx
+	}
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 5+len(extra))
+	if counter != nil && l.every > 1 {
+		n := counter.Add(1)
+		if (n-1)%l.every != 0 {
+			return
+		}
+		attrs = append(attrs, slog.Uint64("count", n))
+	}
+	if ev != nil {
+		attrs = append(attrs, slog.String("topic", ev.Topic))
+		if ev.ID != "" {
+			attrs = append(attrs, slog.String("event_id", ev.ID))
+		}
+	}
+	if sub != nil {
+		attrs = append(attrs, slog.String("pattern", sub.pattern), slog.Uint64("subscriber_id", sub.id))
+	}
+	l.logger.LogAttrs(ctx, level, msg, append(attrs, extra...)...)
+}
+
+func (b *Bus[EData]) logSubscription(msg string, sub Subscriber[EData]) {
+	if b.log != nil {
+		logRecord[EData](b.log, slog.LevelDebug, nil, msg, &sub, nil)
+	}
+}
+
+func (b *Bus[EData]) logUnhandled(ev Event[EData]) {
+	if b.log != nil {
+		logRecord(b.log, slog.LevelInfo, &b.log.unhandled, "unhandled event", nil, &ev)
+	}
+}
+
+func (b *Bus[EData]) logSlow(s SlowHandler[EData]) {
+	if b.log != nil {
+		logRecord(b.log, slog.LevelWarn, &b.log.slow, "slow handler", &s.Subscriber, &s.Event,
+			slog.Duration("elapsed", s.Elapsed))
+	}
+}
+
+func (b *Bus[EData]) logDropped(dl DeadLetter[EData]) {
+	if b.log != nil {
+		logRecord(b.log, slog.LevelWarn, &b.log.dropped, "delivery dropped", &dl.Subscriber, &dl.Event,
+			slog.Any("error", dl.Err))
+	}
+}
+
+func (b *Bus[EData]) logPanic(pe *PanicError, sub Subscriber[EData], ev Event[EData]) {
+	if b.log != nil {
+		logRecord(b.log, slog.LevelError, nil, "handler panicked", &sub, &ev,
+			slog.Any("panic", pe.Value), slog.String("stack", string(pe.Stack)))
+	}
+}
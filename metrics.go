@@ -0,0 +1,237 @@
+/*
+ * Holds bus metrics
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"expvar"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Receives bus measurements, see WithMetrics. Methods are called synchronously from publishing and handling
+// goroutines, concurrently, so they must be safe for concurrent use and fast.
+// Collector is the built-in implementation. To export metrics elsewhere, e.g. to Prometheus,
+// implement Metrics with counters and histograms of that system.
+//
+// Subscriber pattern is empty for the unhandled sink.
+type Metrics interface {
+	// Event was published to the topic: matched and counted for delivery. Not called if publish failed early.
+	Published(topic string)
+	// Published event had no subscribers accepting it, see Bus.SetUnhandledSink.
+	Unhandled(topic string)
+	// Publish looked up subscribers of its topic in the topic cache, see WithTopicCacheSize.
+	CacheLookup(hit bool)
+	// Handler is about to be called. Called once per delivery: retries (see WithRetry) are part of the same call.
+	HandlerStarted(pattern string)
+	// Handler returned after `elapsed`, with `err` if it failed, or `*PanicError` if it panicked.
+	HandlerFinished(pattern string, elapsed time.Duration, err error)
+	// Handler panicked. Called before HandlerFinished.
+	Panicked(pattern string)
+	// Delivery was dropped or rejected before it was handled, see ReasonDropped.
+	Dropped(pattern string, err error)
+}
+
+// Reports bus measurements to `m`. See Metrics.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// Upper bounds of handler latency histogram buckets, see Histogram.
+var latencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Maximal number of topics Collector counts publishes of separately, see MetricsSnapshot.OtherTopics.
+const MaxCollectedTopics = 1024
+
+// In-memory Metrics implementation. Safe for concurrent use. Zero value is ready to use.
+// One collector may be shared by several buses, then it sums up their measurements.
+type Collector struct {
+	published   sync.Map // Topic → *atomic.Uint64
+	topics      atomic.Int64
+	otherTopics atomic.Uint64
+	unhandled   atomic.Uint64
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	delivered   atomic.Uint64
+	failed      atomic.Uint64
+	panics      atomic.Uint64
+	dropped     atomic.Uint64
+	inFlight    atomic.Int64
+	latency     sync.Map // Pattern → *histogram
+}
+
+// Point-in-time copy of Collector measurements.
+type MetricsSnapshot struct {
+	Published   map[string]uint64 // Publishes by topic, up to MaxCollectedTopics topics
+	OtherTopics uint64            // Publishes to topics beyond MaxCollectedTopics
+	Unhandled   uint64            // Events without accepting subscribers
+	CacheHits   uint64
+	CacheMisses uint64
+	Delivered   uint64               // Handler calls completed, including failed ones
+	Failed      uint64               // Handler calls that returned error or panicked
+	Panics      uint64               // Handler calls that panicked
+	Dropped     uint64               // Deliveries dropped before handling
+	InFlight    int64                // Handlers running at the moment
+	Latency     map[string]Histogram // Handler latency by subscriber pattern
+}
+
+// Returns share of publishes served from the topic cache, zero if there were none.
+func (s MetricsSnapshot) CacheHitRate() float64 {
+	total := s.CacheHits + s.CacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(total)
+}
+
+// Latency histogram. Counts[i] is the number of observations greater than Bounds[i-1] and not greater than Bounds[i].
+// The last count, Counts[len(Bounds)], is of observations greater than all bounds.
+type Histogram struct {
+	Bounds []time.Duration // From 100µs to 5s
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+type histogram struct {
+	counts []atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := len(latencyBuckets)
+	for j, bound := range latencyBuckets {
+		if d <= bound {
+			i = j
+			break
+		}
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{Bounds: slices.Clone(latencyBuckets), Counts: make([]uint64, len(h.counts)), Sum: time.Duration(h.sum.Load())}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+func (c *Collector) Published(topic string) {
+	if n, ok := c.published.Load(topic); ok {
+		n.(*atomic.Uint64).Add(1)
+		return
+	}
+	if c.topics.Load() >= MaxCollectedTopics {
+		c.otherTopics.Add(1)
+		return
+	}
+	n, loaded := c.published.LoadOrStore(topic, &atomic.Uint64{})
+	if !loaded {
+		c.topics.Add(1)
+	}
+	n.(*atomic.Uint64).Add(1)
+}
+
+func (c *Collector) Unhandled(topic string) {
+	c.unhandled.Add(1)
+}
+
+func (c *Collector) CacheLookup(hit bool) {
+	if hit {
+		c.cacheHits.Add(1)
+	} else {
+		c.cacheMisses.Add(1)
+	}
+}
+
+func (c *Collector) HandlerStarted(pattern string) {
+	c.inFlight.Add(1)
+}
+
+func (c *Collector) HandlerFinished(pattern string, elapsed time.Duration, err error) {
+	c.inFlight.Add(-1)
+	c.delivered.Add(1)
+	if err != nil {
+		c.failed.Add(1)
+	}
+
+	h, ok := c.latency.Load(pattern)
+	if !ok {
+		h, _ = c.latency.LoadOrStore(pattern, &histogram{counts: make([]atomic.Uint64, len(latencyBuckets)+1)})
+	}
+	h.(*histogram).observe(elapsed)
+}
+
+func (c *Collector) Panicked(pattern string) {
+	c.panics.Add(1)
+}
+
+func (c *Collector) Dropped(pattern string, err error) {
+	c.dropped.Add(1)
+}
+
+// Returns copy of the measurements. Counters are read one by one, so they may be slightly inconsistent
+// with each other under load.
+func (c *Collector) Snapshot() MetricsSnapshot {
+	s := MetricsSnapshot{
+		Published:   map[string]uint64{},
+		OtherTopics: c.otherTopics.Load(),
+		Unhandled:   c.unhandled.Load(),
+		CacheHits:   c.cacheHits.Load(),
+		CacheMisses: c.cacheMisses.Load(),
+		Delivered:   c.delivered.Load(),
+		Failed:      c.failed.Load(),
+		Panics:      c.panics.Load(),
+		Dropped:     c.dropped.Load(),
+		InFlight:    c.inFlight.Load(),
+		Latency:     map[string]Histogram{},
+	}
+	c.published.Range(func(topic, n any) bool {
+		s.Published[topic.(string)] = n.(*atomic.Uint64).Load()
+		return true
+	})
+	c.latency.Range(func(pattern, h any) bool {
+		s.Latency[pattern.(string)] = h.(*histogram).snapshot()
+		return true
+	})
+	return s
+}
+
+// Returns expvar variable reporting collector's Snapshot as JSON, e.g.:
+//
+//	expvar.Publish("events", collector.Expvar())
+func (c *Collector) Expvar() expvar.Var {
+	return expvar.Func(func() any {
+		return c.Snapshot()
+	})
+}
@@ -0,0 +1,150 @@
+/*
+ * Holds publish and handler middleware
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import "slices"
+
+// Next step of publishing, see PublishMiddleware.
+type PublishFunc[EData any] func(ev *Event[EData]) error
+
+// Wraps publishing of events. Middleware gets the event and `next` step, and may:
+//   - modify the event, including its Topic (to reroute it), Data and Envelope, then call `next` with it;
+//   - reject the event by returning error without calling `next`;
+//   - drop the event silently by returning nil without calling `next`;
+//   - act after `next` returns, e.g. to inspect or wrap its error.
+//
+// Middleware registered with Bus.UsePublishMiddleware wraps publishing as a whole: it's called before
+// the topic is checked and matched, and its error is returned from Publish (or PublishSync) as is.
+// At this point Headers aren't shared yet, so they may be modified in place.
+//
+// Middleware set per subscription with Subscription.PublishMiddleware wraps dispatching of the event
+// to that subscriber, after matching and filtering. Its event, including Envelope, is subscriber's own copy,
+// but Headers are shared with other subscribers: replace them with a modified copy instead of modifying them.
+// Rejected delivery is done for waiters and sent to dead letters as dropped,
+// its error is returned from Publish (or recorded to the Result by PublishSync).
+// Silently dropped delivery is just done. Changing Topic doesn't reroute the delivery.
+//
+// Middleware is called on the publishing goroutine, in registration order: the first registered is outermost.
+// Bus-wide middleware runs before subscription one.
+type PublishMiddleware[EData any] func(next PublishFunc[EData]) PublishFunc[EData]
+
+// Next step of handling, see HandlerMiddleware.
+type HandlerFunc[EData any] func(ev Event[EData]) error
+
+// Wraps every handler invocation, including retries (see WithRetry) and the unhandled sink.
+// Middleware may modify the event passed to `next`, skip `next`, or change its error.
+// It's called by the handler's goroutine, within handler's timeout and panic recovery,
+// so its panics and errors are treated the same way as handler's ones.
+//
+// Middleware runs in registration order, the first registered being outermost:
+// bus-wide middleware (see Bus.UseHandlerMiddleware) first, then subscription one (see Subscription.HandlerMiddleware),
+// then the handler.
+type HandlerMiddleware[EData any] func(next HandlerFunc[EData]) HandlerFunc[EData]
+
+type middleware[EData any] struct {
+	publish []PublishMiddleware[EData]
+	handler []HandlerMiddleware[EData]
+}
+
+// Adds middleware wrapping every subsequent publish, after already registered one.
+// See PublishMiddleware for details and order. No-op if the bus is closed.
+func (b *Bus[EData]) UsePublishMiddleware(mw ...PublishMiddleware[EData]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update(func(s *snapshot[EData]) {
+		s.middleware.publish = append(s.middleware.publish[:len(s.middleware.publish):len(s.middleware.publish)], mw...)
+	})
+}
+
+// Adds middleware wrapping every subsequent handler invocation of all subscribers, after already registered one.
+// See HandlerMiddleware for details and order. No-op if the bus is closed.
+func (b *Bus[EData]) UseHandlerMiddleware(mw ...HandlerMiddleware[EData]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update(func(s *snapshot[EData]) {
+		s.middleware.handler = append(s.middleware.handler[:len(s.middleware.handler):len(s.middleware.handler)], mw...)
+	})
+}
+
+// Returns subscriber's middleware from its subscription settings, nil if there is none.
+func newMiddleware[EData any](s Subscription[EData]) *middleware[EData] {
+	if len(s.PublishMiddleware) == 0 && len(s.HandlerMiddleware) == 0 {
+		return nil
+	}
+	return &middleware[EData]{
+		publish: slices.DeleteFunc(slices.Clone(s.PublishMiddleware), func(mw PublishMiddleware[EData]) bool { return mw == nil }),
+		handler: slices.DeleteFunc(slices.Clone(s.HandlerMiddleware), func(mw HandlerMiddleware[EData]) bool { return mw == nil }),
+	}
+}
+
+// Wraps `fn` into middleware, the first one being outermost.
+func chain[F any, M ~func(F) F](mws []M, fn F) F {
+	for i := len(mws) - 1; i >= 0; i-- {
+		fn = mws[i](fn)
+	}
+	return fn
+}
+
+// Passes event through bus-wide publish middleware to `publish`.
+// Returns nil Result if middleware didn't let the event through.
+func intercept[EData any](mws []PublishMiddleware[EData], ev Event[EData], publish func(ev Event[EData]) (*Result, error)) (*Result, error) {
+	var res *Result
+	err := chain(mws, func(next *Event[EData]) (err error) {
+		ev.take(next)
+		res, err = publish(ev)
+		return err
+	})(&ev)
+	return res, err
+}
+
+// Hands delivery over to dispatch (or to dispatchSync, if `sync`) through subscriber's publish middleware, if any.
+// Returns false if delivery didn't get through, it isn't completed then.
+func (b *Bus[EData]) send(d *delivery[EData], sync bool) (bool, error) {
+	if d.sub.middleware == nil || len(d.sub.middleware.publish) == 0 {
+		err := b.sendNow(d, sync)
+		return err == nil, err
+	}
+
+	sent := false
+	ev := d.ev
+	err := chain(d.sub.middleware.publish, func(ev *Event[EData]) error {
+		d.ev.take(ev)
+		if err := b.sendNow(d, sync); err != nil {
+			return err
+		}
+		sent = true
+		return nil
+	})(&ev)
+	return sent, err
+}
+
+func (b *Bus[EData]) sendNow(d *delivery[EData], sync bool) error {
+	if sync {
+		return b.dispatchSync(d)
+	}
+	return b.dispatch(d)
+}
+
+// Returns subscriber's handler wrapped into handler middleware: bus-wide, then subscriber's own.
+func (d *delivery[EData]) handler() HandlerFunc[EData] {
+	h := HandlerFunc[EData](d.sub.handler)
+	if d.sub.middleware != nil {
+		h = chain(d.sub.middleware.handler, h)
+	}
+	return chain(d.bus.state.Load().middleware.handler, h)
+}
@@ -0,0 +1,178 @@
+/*
+ * Holds bus options
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/amanofbits/gogoevents/internal/wildcard"
+)
+
+// Bus configuration option, see New.
+type Option func(*options)
+
+type options struct {
+	dispatcher     Dispatcher
+	syntax         syntax
+	handlerTimeout time.Duration
+	topicCacheSize int
+	requestTimeout time.Duration
+	source         string
+	metrics        Metrics
+	logger         *slog.Logger
+	logSampling    int
+	tracer         Tracer
+}
+
+// Default number of topics whose matching subscribers are cached, see WithTopicCacheSize.
+const DefaultTopicCacheSize = 1024
+
+func newOptions(opts []Option) options {
+	o := options{dispatcher: GoDispatcher{}, syntax: globSyntax{}, topicCacheSize: DefaultTopicCacheSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Sets dispatcher used by Publish to run handlers. Default is GoDispatcher.
+// Bus doesn't own the dispatcher and doesn't close it.
+func WithDispatcher(d Dispatcher) Option {
+	return func(o *options) {
+		if d != nil {
+			o.dispatcher = d
+		}
+	}
+}
+
+// Switches bus to hierarchical (MQTT/AMQP-like) topics, which consist of levels divided by `separator`,
+// "." if empty. By default, '*' in pattern matches any characters, so "orders.*" matches "orders.eu.created".
+// In hierarchical mode, pattern level may be:
+//   - a literal, matching the same topic level;
+//   - '+' or '*', matching exactly one level: "orders.*" matches "orders.created", but not "orders.eu.created";
+//   - '#' or '>', as the last level only, matching any number of trailing levels, including none:
+//     "orders.#" matches "orders", "orders.created" and "orders.eu.created".
+//
+// Subscribe rejects patterns with empty levels, wildcards mixed with other characters in one level,
+// or multi-level wildcard not at the end, with `ErrInvalidPattern`.
+// Publish rejects topics containing any of wildcard characters with `ErrIllegalWildcard`.
+// So, `separator` must not contain wildcard characters, WithHierarchy panics otherwise.
+func WithHierarchy(separator string) Option {
+	if strings.ContainsAny(separator, wildcard.HierarchyWildcards) {
+		panic(fmt.Sprintf("gogoevents: hierarchy separator %q contains wildcard characters %q", separator, wildcard.HierarchyWildcards))
+	}
+	return func(o *options) {
+		if separator == "" {
+			separator = "."
+		}
+		o.syntax = hierarchySyntax{wildcard.Hierarchy{Sep: separator}}
+	}
+}
+
+// Sets default timeout for every handler, including unhandled sink. See WithTimeout.
+// Zero (default) means no timeout.
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.handlerTimeout = timeout
+	}
+}
+
+// Sets how many topics may have their matching subscribers cached, DefaultTopicCacheSize by default.
+// When the cache is full, a topic not published recently is evicted. Zero or negative size disables caching,
+// so every Publish matches subscriptions anew. See Bus.CacheStats.
+func WithTopicCacheSize(size int) Option {
+	return func(o *options) {
+		o.topicCacheSize = size
+	}
+}
+
+// Sets source of events published by the bus, see Envelope.Source. May be overridden by WithEventSource.
+func WithSource(source string) Option {
+	return func(o *options) {
+		o.source = source
+	}
+}
+
+// Sets default timeout for Bus.Request and Bus.RequestMany, applied if their context has no deadline.
+// Zero (default) means no timeout.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = timeout
+	}
+}
+
+// Subscription option, see Bus.Subscribe.
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	retry         *RetryPolicy
+	timeout       time.Duration
+	mailboxSize   int
+	mailboxPolicy OverflowPolicy
+	ordered       bool
+	limit         *int // Deliveries limit, if set
+}
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+	o := subscribeOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Gives subscriber its own FIFO mailbox of `capacity` events, served by a dedicated goroutine.
+// Subscriber then receives events strictly in the order they were published, one at a time,
+// bypassing bus' Dispatcher. `policy` is applied when the mailbox is full.
+//
+// On Unsubscribe or Bus.Close, events already in the mailbox are still delivered.
+func WithMailbox(capacity int, policy OverflowPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.ordered = true
+		o.mailboxSize = capacity
+		o.mailboxPolicy = policy
+	}
+}
+
+// Retries failed handler according to the `policy`. Only this subscriber is re-invoked,
+// in the same goroutine, after the backoff delay. Event is not done for waiters until the last attempt.
+// Event's context cancellation stops retrying. See also Event.Attempt.
+func WithRetry(policy RetryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = &policy
+	}
+}
+
+// Limits time handler may run, overriding bus-wide WithHandlerTimeout. Timeout covers all retry attempts.
+// When it expires, handler's context (Event.Context) is cancelled with `ErrHandlerTimeout` as its cause,
+// the event is marked done with `ErrHandlerTimeout` failure, and reported to the watchdog (see Bus.SetWatchdog).
+// Go has no way to stop the handler, so it's up to handler to respect the context.
+func WithTimeout(timeout time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.timeout = timeout
+	}
+}
+
+// Removes subscriber after `n` deliveries, even if some of them failed. See Bus.SubscribeN.
+// Subscribe returns `ErrInvalidLimit` if `n` isn't positive.
+func WithLimit(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.limit = &n
+	}
+}
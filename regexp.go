@@ -0,0 +1,88 @@
+/*
+ * Holds regular expression subscriptions
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"fmt"
+	"regexp"
+	resyntax "regexp/syntax"
+)
+
+// Subscribes `handler` to topics matching `re`. Like regexp.MatchString, `re` matches if it matches any part
+// of the topic, so use "^" and "$" to anchor it: `^orders\.\d+$`.
+// Regexp subscribers may be mixed with pattern ones on the same bus, their Pattern is `re.String()`.
+// See SubscribeOption for available options.
+// Returns `ErrInvalidPattern` if `re` is nil, `ErrClosed` if the bus is closed.
+func (b *Bus[EData]) SubscribeRegexp(re *regexp.Regexp, handler func(ev Event[EData]), opts ...SubscribeOption) (Subscriber[EData], error) {
+	return b.SubscribeRegexpE(re, func(ev Event[EData]) error {
+		handler(ev)
+		return nil
+	}, opts...)
+}
+
+// Same as SubscribeRegexp, but `handler` can report failure, see SubscribeE.
+func (b *Bus[EData]) SubscribeRegexpE(re *regexp.Regexp, handler func(ev Event[EData]) error, opts ...SubscribeOption) (Subscriber[EData], error) {
+	if re == nil {
+		return Subscriber[EData]{}, fmt.Errorf("%w: nil regexp", ErrInvalidPattern)
+	}
+	return b.subscribe(newRegexpMatcher(re), Subscription[EData]{}, handler, opts)
+}
+
+// Same as SubscribeRegexpE, with settings of `s`, see SubscribeWith.
+func (b *Bus[EData]) SubscribeRegexpWith(re *regexp.Regexp, s Subscription[EData], handler func(ev Event[EData]) error, opts ...SubscribeOption) (Subscriber[EData], error) {
+	if re == nil {
+		return Subscriber[EData]{}, fmt.Errorf("%w: nil regexp", ErrInvalidPattern)
+	}
+	return b.subscribe(newRegexpMatcher(re), s, handler, opts)
+}
+
+type regexpMatcher struct {
+	re     *regexp.Regexp
+	prefix string
+}
+
+func newRegexpMatcher(re *regexp.Regexp) regexpMatcher {
+	m := regexpMatcher{re: re}
+	// Literal prefix begins any match, but it's a topic prefix only if the match is anchored at the start.
+	if isAnchored(re) {
+		m.prefix, _ = re.LiteralPrefix()
+	}
+	return m
+}
+
+func isAnchored(re *regexp.Regexp) bool {
+	parsed, err := resyntax.Parse(re.String(), resyntax.Perl)
+	if err != nil {
+		return false
+	}
+	if parsed.Op == resyntax.OpConcat && len(parsed.Sub) > 0 {
+		parsed = parsed.Sub[0]
+	}
+	return parsed.Op == resyntax.OpBeginText
+}
+
+func (m regexpMatcher) Match(topic string) bool {
+	return m.re.MatchString(topic)
+}
+
+// Regexp is never exact, even a literal one may match a part of the topic.
+func (m regexpMatcher) LiteralPrefix() (string, bool) {
+	return m.prefix, false
+}
+
+func (m regexpMatcher) String() string {
+	return m.re.String()
+}
@@ -0,0 +1,169 @@
+/*
+ * Holds request/reply messaging
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrNoResponders = errors.New("no responders")
+	ErrNoReply      = errors.New("responders didn't reply")
+	ErrNotRequest   = errors.New("event is not a request")
+	ErrRequestDone  = errors.New("request is already done")
+)
+
+// Private reply inbox of a single request.
+type inbox[EData any] struct {
+	responders int // Matched subscribers, set before dispatch
+	ready      chan struct{}
+
+	mu      sync.Mutex
+	replies []EData
+	closed  bool
+}
+
+func newInbox[EData any]() *inbox[EData] {
+	return &inbox[EData]{ready: make(chan struct{}, 1)}
+}
+
+func (in *inbox[EData]) send(data EData) error {
+	in.mu.Lock()
+	if in.closed {
+		in.mu.Unlock()
+		return ErrRequestDone
+	}
+	in.replies = append(in.replies, data)
+	in.mu.Unlock()
+
+	select {
+	case in.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Returns replies received since the last call.
+func (in *inbox[EData]) take() []EData {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	replies := in.replies
+	in.replies = nil
+	return replies
+}
+
+func (in *inbox[EData]) close() {
+	in.mu.Lock()
+	in.closed = true
+	in.mu.Unlock()
+}
+
+// Replies to the request event, see Bus.Request. May be called multiple times, for Bus.RequestMany.
+// Reply must be sent before the handler returns or calls Done, as the request ends once all responders are done.
+// Returns `ErrNotRequest` if the event isn't a request, `ErrRequestDone` if requester doesn't wait anymore.
+func (ev *Event[EData]) Reply(data EData) error {
+	if ev.inbox == nil {
+		return ErrNotRequest
+	}
+	return ev.inbox.send(data)
+}
+
+// Returns true if the event is a request, i.e. handler is expected to Reply.
+func (ev *Event[EData]) IsRequest() bool {
+	return ev.inbox != nil
+}
+
+// Publishes request event and returns the first reply.
+// Request ends when `ctx` is done, or the request timeout expires (see WithRequestTimeout),
+// or all responders are done without replying. Responders' context (Event.Context) carries the timeout,
+// and is cancelled once the request ends.
+//
+// Returns `ErrNoResponders` if no subscriber accepted the event. It's passed to the unhandled sink then, as usual.
+// Returns `ErrNoReply` if all responders are done without replying, `ctx.Err()` if `ctx` is done first,
+// and the same errors as PublishContext.
+func (b *Bus[EData]) Request(ctx context.Context, topic string, data EData, opts ...PublishOption) (reply EData, err error) {
+	replies, err := b.RequestMany(ctx, topic, data, 1, opts...)
+	if err != nil {
+		return reply, err
+	}
+	if len(replies) == 0 {
+		return reply, ErrNoReply
+	}
+	return replies[0], nil
+}
+
+// Scatter-gather: publishes request event and returns replies of all responders, up to `limit` if it's positive.
+// Gathering stops when `limit` replies are received, or all responders are done, or `ctx` is done,
+// or the request timeout expires (see WithRequestTimeout). In the last two cases, replies received so far
+// are returned with `ctx.Err()`, so use context deadline to gather replies for a limited time.
+//
+// Returns `ErrNoResponders` if no subscriber accepted the event, and the same errors as PublishContext.
+func (b *Bus[EData]) RequestMany(ctx context.Context, topic string, data EData, limit int, opts ...PublishOption) ([]EData, error) {
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); !ok && b.requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.requestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	in := newInbox[EData]()
+	defer in.close()
+
+	res, err := b.publish(ctx, topic, data, in, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if in.responders == 0 {
+		// Unhandled sink still gets the request, so its context must outlive the call.
+		go func() {
+			res.wg.Wait()
+			cancel()
+		}()
+		return nil, ErrNoResponders
+	}
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		res.wg.Wait()
+		close(done)
+	}()
+
+	var replies []EData
+	for {
+		replies = append(replies, in.take()...)
+		if limit > 0 && len(replies) >= limit {
+			return replies[:limit], nil
+		}
+
+		select {
+		case <-in.ready:
+		case <-done:
+			in.close()
+			replies = append(replies, in.take()...)
+			if limit > 0 && len(replies) > limit {
+				replies = replies[:limit]
+			}
+			return replies, nil
+		case <-ctx.Done():
+			return replies, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Holds publish result
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Handler failure: returned error, recovered panic (`*PanicError`), timeout (`ErrHandlerTimeout`)
+// or dropped delivery (e.g. `ErrQueueFull`).
+type HandlerError struct {
+	SubscriberID uint64 // See Subscriber.ID. Zero for the unhandled sink
+	Pattern      string // See Subscriber.Pattern. Empty for the unhandled sink
+	Topic        string
+	Err          error
+}
+
+func (e *HandlerError) Error() string {
+	if e.SubscriberID == 0 {
+		return fmt.Sprintf("unhandled sink on %s: %v", e.Topic, e.Err)
+	}
+	return fmt.Sprintf("subscriber %d (%s) on %s: %v", e.SubscriberID, e.Pattern, e.Topic, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// Result of Publish. Tracks handlers of a single published event.
+type Result struct {
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+}
+
+// Waits until the event is done by all handlers and returns their failures joined, or nil.
+// Every failure is a `*HandlerError`.
+//
+// Note: handler that calls Event.Done before it returns is not waited for,
+// and its failure, if any, may be missed.
+func (r *Result) Wait() error {
+	r.wg.Wait()
+	return r.Err()
+}
+
+// Same as Wait, but returns `ctx.Err()` as soon as `ctx` is done.
+// Note: in case of ctx expiration, an internal goroutine keeps waiting for handlers until they're done.
+func (r *Result) WaitContext(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return r.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns handler failures reported so far, joined, or nil. Doesn't wait.
+func (r *Result) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return errors.Join(r.errs...)
+}
+
+func (r *Result) addErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.errs = append(r.errs, err)
+}
@@ -0,0 +1,83 @@
+/*
+ * Holds handler retry policy
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Defines how a failed handler is retried. See WithRetry.
+// Only errors returned by handler are retried, panics are not.
+type RetryPolicy struct {
+	MaxAttempts    int                  // Total number of attempts, including the first one. 1 or less means no retries
+	InitialBackoff time.Duration        // Delay before the 2nd attempt
+	MaxBackoff     time.Duration        // Upper bound for the delay. Zero means no bound but the maximal Duration
+	Multiplier     float64              // Delay growth per attempt. Less than 1 means 2
+	Jitter         float64              // Fraction of the delay, [0, 1], that is randomly subtracted from it
+	Retryable      func(err error) bool // Tells whether error is worth retrying. Nil means every error is
+}
+
+// Returns delay before the attempt following `attempt` failed with `err`,
+// or false if there should be no more attempts.
+func (p *RetryPolicy) next(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	if p.Retryable != nil && !p.Retryable(err) {
+		return 0, false
+	}
+
+	mul := p.Multiplier
+	if mul < 1 {
+		mul = 2
+	}
+	if p.InitialBackoff <= 0 {
+		return 0, true
+	}
+	// Unbounded delay still has to fit time.Duration, otherwise it would overflow to negative one.
+	bound := float64(math.MaxInt64)
+	if p.MaxBackoff > 0 {
+		bound = float64(p.MaxBackoff)
+	}
+	delay := min(float64(p.InitialBackoff)*math.Pow(mul, float64(attempt-1)), bound)
+	if jitter := min(max(p.Jitter, 0), 1); jitter > 0 {
+		delay -= delay * jitter * rand.Float64()
+	}
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64, true
+	}
+	return time.Duration(delay), true
+}
+
+// Sleeps for `d`, returns false if `ctx` is done earlier.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -15,7 +15,43 @@
 
 package gogoevents
 
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Subscription settings typed by bus' event data, so that they're checked at compile time.
+// Zero value means no filtering and no middleware. See Bus.SubscribeWith.
+type Subscription[EData any] struct {
+	// Delivers only events Filter returns true for. Filter is called before dispatch, on the publishing goroutine,
+	// so it must be fast and must not block. If filters of all matching subscribers reject the event,
+	// it's unhandled, see Bus.SetUnhandledSink.
+	Filter func(ev Event[EData]) bool
+	// Wraps dispatching of events to this subscriber, see PublishMiddleware.
+	PublishMiddleware []PublishMiddleware[EData]
+	// Wraps this subscriber's handler, see HandlerMiddleware.
+	HandlerMiddleware []HandlerMiddleware[EData]
+}
+
 type Subscriber[EData any] struct {
-	id      uint64
-	handler func(ev Event[EData])
+	id         uint64
+	handler    func(ev Event[EData]) error
+	mailbox    *WorkerPool // Single-worker pool, if subscriber receives events in order
+	retry      *RetryPolicy
+	timeout    time.Duration
+	pattern    string
+	matcher    matcher // Compiled pattern
+	filter     func(ev Event[EData]) bool
+	left       *atomic.Int64      // Deliveries left, if limited, see Bus.SubscribeN
+	middleware *middleware[EData] // Subscriber's own middleware, if any
+}
+
+// Returns unique subscriber id. Zero for the unhandled sink.
+func (s Subscriber[EData]) ID() uint64 {
+	return s.id
+}
+
+// Returns pattern subscriber is subscribed with. Empty for the unhandled sink.
+func (s Subscriber[EData]) Pattern() string {
+	return s.pattern
 }
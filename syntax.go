@@ -0,0 +1,63 @@
+/*
+ * Holds topic pattern syntaxes
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import (
+	"github.com/amanofbits/gogoevents/internal/wildcard"
+)
+
+// Topic pattern syntax of a bus.
+type syntax interface {
+	// Validates and parses pattern.
+	compile(pattern string) (matcher, error)
+	// Returns index of first wildcard symbol in the topic, or -1 if not found.
+	wildcardIndex(topic string) int
+}
+
+// Compiled pattern.
+type matcher interface {
+	// Returns true if the topic matches the pattern.
+	Match(topic string) bool
+	// Returns the part every matching topic starts with.
+	// `exact` is true if only topic equal to the prefix matches.
+	LiteralPrefix() (prefix string, exact bool)
+	// Returns normalized pattern.
+	String() string
+}
+
+// Default syntax, see wildcard.Glob. Topics are literal, so they may contain any characters.
+type globSyntax struct{}
+
+func (globSyntax) compile(pattern string) (matcher, error) {
+	return wildcard.Compile(pattern)
+}
+
+func (globSyntax) wildcardIndex(topic string) int {
+	return -1
+}
+
+// Level-aware syntax, see WithHierarchy.
+type hierarchySyntax struct {
+	h wildcard.Hierarchy
+}
+
+func (s hierarchySyntax) compile(pattern string) (matcher, error) {
+	return s.h.Compile(pattern)
+}
+
+func (s hierarchySyntax) wildcardIndex(topic string) int {
+	return s.h.Index(topic)
+}
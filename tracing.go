@@ -0,0 +1,120 @@
+/*
+ * Holds tracing hooks
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import "context"
+
+// Traces publishing and handling of events, see WithTracer. Methods are called concurrently,
+// so they must be safe for concurrent use. Every started span is ended exactly once.
+//
+// Trace context travels with events two ways. Context returned by StartPublish becomes event's context,
+// so in-process handlers and events they publish (see CausedBy) continue the trace.
+// StartPublish also injects it into event's headers, which StartHandle may extract it from,
+// e.g. for events received from another process and republished with their headers (see WithHeaders).
+//
+// OpenTelemetry bridge may implement Tracer outside of this module, Headers being its TextMapCarrier:
+//
+//	type otelTracer struct {
+//		tracer     trace.Tracer
+//		propagator propagation.TextMapPropagator
+//	}
+//
+//	func (t otelTracer) StartPublish(ctx context.Context, info gogoevents.TraceInfo) (context.Context, gogoevents.Span) {
+//		ctx, span := t.tracer.Start(ctx, "publish "+info.Topic, trace.WithSpanKind(trace.SpanKindProducer),
+//			trace.WithAttributes(attribute.String("messaging.message.id", info.EventID)))
+//		t.propagator.Inject(ctx, info.Headers)
+//		return ctx, otelSpan{span}
+//	}
+//
+//	func (t otelTracer) StartMatch(ctx context.Context, info gogoevents.TraceInfo) gogoevents.Span {
+//		_, span := t.tracer.Start(ctx, "match "+info.Topic)
+//		return otelSpan{span}
+//	}
+//
+//	func (t otelTracer) StartHandle(ctx context.Context, info gogoevents.TraceInfo) (context.Context, gogoevents.Span) {
+//		if !trace.SpanContextFromContext(ctx).IsValid() {
+//			ctx = t.propagator.Extract(ctx, info.Headers)
+//		}
+//		ctx, span := t.tracer.Start(ctx, "handle "+info.Pattern, trace.WithSpanKind(trace.SpanKindConsumer))
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ span trace.Span }
+//
+//	func (s otelSpan) End(err error) {
+//		if err != nil {
+//			s.span.RecordError(err)
+//			s.span.SetStatus(codes.Error, err.Error())
+//		}
+//		s.span.End()
+//	}
+type Tracer interface {
+	// Starts span of publishing an event, covering publish middleware, matching and dispatching.
+	// For PublishSync, it covers handlers too. `ctx` is the context event is published with.
+	// Returns context carrying the span. `info.Headers` are event's own headers, the trace context is to be set there.
+	StartPublish(ctx context.Context, info TraceInfo) (context.Context, Span)
+	// Starts span of matching subscribers to the published event. `ctx` is the one returned by StartPublish.
+	StartMatch(ctx context.Context, info TraceInfo) Span
+	// Starts span of handling the event by a subscriber, including retries. `ctx` is event's context.
+	// Returned context becomes handler's Event.Context. `info.Headers` must not be modified.
+	StartHandle(ctx context.Context, info TraceInfo) (context.Context, Span)
+}
+
+// Traced operation, see Tracer.
+type Span interface {
+	// Ends the span. `err` is operation's failure, if any: publish or match error, handler error or `*PanicError`.
+	End(err error)
+}
+
+// Traced operation details, see Tracer.
+type TraceInfo struct {
+	Topic        string
+	EventID      string
+	Headers      Headers // Event headers, never nil
+	Pattern      string  // Subscriber's pattern, for handler spans only. Empty for the unhandled sink
+	SubscriberID uint64  // Subscriber's id, for handler spans only. Zero for the unhandled sink
+}
+
+// Traces bus with `t`. See Tracer.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// Starts publish span, setting its context as event's context.
+func (b *Bus[EData]) tracePublish(ev *Event[EData]) Span {
+	ctx, span := b.tracer.StartPublish(ev.ctx, TraceInfo{Topic: ev.Topic, EventID: ev.ID, Headers: ev.Headers})
+	ev.ctx = ctx
+	return span
+}
+
+func (b *Bus[EData]) traceMatch(ev Event[EData]) Span {
+	return b.tracer.StartMatch(ev.ctx, TraceInfo{Topic: ev.Topic, EventID: ev.ID, Headers: ev.Headers})
+}
+
+// Starts handler span, setting its context as handler's event context.
+func (d *delivery[EData]) traceHandle() Span {
+	ctx, span := d.bus.tracer.StartHandle(d.ev.ctx, TraceInfo{
+		Topic:        d.ev.Topic,
+		EventID:      d.ev.ID,
+		Headers:      d.ev.Headers,
+		Pattern:      d.sub.pattern,
+		SubscriberID: d.sub.id,
+	})
+	d.ev.ctx = ctx
+	return span
+}
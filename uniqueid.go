@@ -16,8 +16,11 @@
 package gogoevents
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math"
+	"strconv"
 	"sync/atomic"
 )
 
@@ -35,3 +38,23 @@ func newUniqueId() uint64 {
 	}
 	return id
 }
+
+var (
+	// Don't use these directly!
+	_eventId       atomic.Uint64
+	_eventIdPrefix = newEventIDPrefix()
+)
+
+// Random per-process prefix, so that event ids of different processes don't collide.
+func newEventIDPrefix() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("event id prefix: %v", err))
+	}
+	return hex.EncodeToString(b) + "-"
+}
+
+// Returns unique event id: per-process prefix, followed by sequence number.
+func newEventID() string {
+	return _eventIdPrefix + strconv.FormatUint(_eventId.Add(1), 36)
+}
@@ -0,0 +1,85 @@
+/*
+ * Holds helpers waiting for events
+ *
+ * Copyright © 2023 amanofbits
+ *
+ * This file is part of gogoevents.
+ *
+ * gogoevents is free software: you can redistribute it and/or modify
+ * it under the terms of the BSD 3-Clause License as published by the
+ * University of California. See the `LICENSE` file for more details.
+ *
+ * You should have received a copy of the BSD 3-Clause License along with
+ * gogoevents. If not, see <https://opensource.org/licenses/BSD-3-Clause>.
+ */
+
+package gogoevents
+
+import "context"
+
+// Pending wait for an event, see Bus.Expect.
+type Expectation[EData any] struct {
+	bus *Bus[EData]
+	sub Subscriber[EData]
+	got chan Event[EData]
+}
+
+// Starts waiting for the next event matching the `pattern` and `filter`, nil filter accepts any event.
+// Call it before the action that triggers the event, then Expectation.Wait for the event.
+// Temporary subscriber is removed once the event arrives, or Wait or Cancel returns.
+// Returns the same errors as Subscribe.
+func (b *Bus[EData]) Expect(pattern string, filter func(ev Event[EData]) bool) (*Expectation[EData], error) {
+	e := &Expectation[EData]{bus: b, got: make(chan Event[EData], 1)}
+
+	// Once subscriber gets at most one event, so sending never blocks.
+	sub, err := b.SubscribeWith(pattern, Subscription[EData]{Filter: filter}, func(ev Event[EData]) error {
+		e.got <- ev
+		return nil
+	}, WithLimit(1))
+	if err != nil {
+		return nil, err
+	}
+	e.sub = sub
+	return e, nil
+}
+
+// Blocks until expected event arrives and returns it.
+// Returns `ctx.Err()` if `ctx` is done first, `ErrClosed` if the bus is closed first.
+// Must be called once.
+func (e *Expectation[EData]) Wait(ctx context.Context) (Event[EData], error) {
+	defer e.Cancel()
+
+	select {
+	case ev := <-e.got:
+		return ev, nil
+	case <-ctx.Done():
+	case <-e.bus.closed:
+	}
+
+	// Prefer event that arrived at the same time.
+	select {
+	case ev := <-e.got:
+		return ev, nil
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return Event[EData]{}, err
+	}
+	return Event[EData]{}, ErrClosed
+}
+
+// Stops waiting and removes temporary subscriber. It's safe to call Cancel multiple times.
+func (e *Expectation[EData]) Cancel() {
+	e.bus.Unsubscribe(e.sub)
+}
+
+// Blocks until an event matching the `pattern` and `filter` arrives, nil filter accepts any event.
+// Note that event published before the call is not received, use Expect to avoid races with the triggering action.
+// Returns `ctx.Err()` if `ctx` is done first, and the same errors as Subscribe and Expectation.Wait.
+func (b *Bus[EData]) WaitFor(ctx context.Context, pattern string, filter func(ev Event[EData]) bool) (Event[EData], error) {
+	e, err := b.Expect(pattern, filter)
+	if err != nil {
+		return Event[EData]{}, err
+	}
+	return e.Wait(ctx)
+}